@@ -1,23 +1,27 @@
 package blockchain
 
 import (
-	"crypto/md5"
+	"crypto/ed25519"
 	"encoding/hex"
-	"encoding/json"
-	"strings"
+	"errors"
+
+	"github.com/KostasAronis/go-rfs/hashing"
 )
 
 /*
 	TODO:
 	1) Convert Ops to a more generic IContent interface for things other than fs operations to be stored in the blockchain
-	2) Add Timestamp
 */
 
 //Block contains multiple operations on the rfs
 type Block struct {
+	//Version the version of the header encoding, see Header
+	Version uint8
+	//HashAlgorithm the hash function of the block hash, the one configured for the network
+	HashAlgorithm hashing.Algorithm
 	/*
-		PrevHash the hash of the previous block in the chain.
-		Must be MD5 hash and contain {config.Difficulty} number of zeroes at the end of the hex representation.
+		PrevHash the hex encoded hash of the previous block in the chain.
+		Must start with at least {config.Difficulty} zero bits.
 	*/
 	PrevHash string
 	// MinerID the id of the miner that computed this block
@@ -25,36 +29,48 @@ type Block struct {
 	Nonce   uint32
 	//IsOp identifies between Operation and NoOperation blocks
 	IsOp bool
-	//Ops An ordered set of operation records
+	//Timestamp the unix time in nanoseconds at which the miner started mining the block
+	Timestamp int64
+	//Ops An ordered set of operation records, only their merkle root is part of the header
 	Ops []*OpRecord
-	//Confirmations A counter of network confirmations for this block
-	Confirmations int
+	//PublicKey the ed25519 public key of the miner, part of the header so that the pow is bound to the miner
+	PublicKey []byte
+	//Signature the ed25519 signature of the block hash by the miner, see Sign. Not part of the header
+	Signature []byte
+	//hash the cached hash of the block, see Hash
+	hash string
 }
 
-//ComputeHash computes the current hash
-func (b *Block) ComputeHash() (string, error) {
-	// buf := &bytes.Buffer{}
-	// err := binary.Write(buf, binary.LittleEndian, b)
-	bytes, err := json.Marshal(b)
+//Hash returns the hash of the block, computing it only on the first call.
+//The block must not be modified after Hash has been called.
+func (b *Block) Hash() (string, error) {
+	if b.hash != "" {
+		return b.hash, nil
+	}
+	hash, err := b.ComputeHash()
 	if err != nil {
 		return "", err
 	}
-	h := md5.New()
-	_, err = h.Write(bytes)
+	b.hash = hash
+	return hash, nil
+}
+
+//ComputeHash computes the current hash of the block header, see Header
+func (b *Block) ComputeHash() (string, error) {
+	err := b.checkVersion()
 	if err != nil {
 		return "", err
 	}
-	str := hex.EncodeToString(h.Sum(nil))
-	return str, nil
+	return HashHeader(b.HashAlgorithm, b.Header()), nil
 }
 
-//IsValid computes the current hash, checks it against stored hash and also checks the POW of the block
+//IsValid checks the POW of the block using its cached hash
 func (b *Block) IsValid(difficulty int) (bool, error) {
-	hash, err := b.ComputeHash()
+	hash, err := b.Hash()
 	if err != nil {
 		return false, err
 	}
-	return validPOW(hash, difficulty), nil
+	return ValidPOW(hash, difficulty), nil
 }
 
 //HasValidNonce computes the current hash and checks the pow of the block
@@ -63,15 +79,40 @@ func (b *Block) HasValidNonce(difficulty int) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return validPOW(hash, difficulty), nil
+	return ValidPOW(hash, difficulty), nil
 }
 
-//ValidPOW checks just the last {difficulty} characters of the hash
-func validPOW(hash string, difficulty int) bool {
-	lastNDigits := hash[len(hash)-int(difficulty):]
-	nZeros := strings.Repeat("0", int(difficulty))
-	if lastNDigits == nZeros {
-		return true
+//ValidPOW checks that the hex encoded hash starts with at least {difficulty} zero bits
+func ValidPOW(hash string, difficulty int) bool {
+	digest, err := hex.DecodeString(hash)
+	if err != nil {
+		return false
+	}
+	return hashing.LeadingZeroBits(digest) >= difficulty
+}
+
+//Sign signs the hash of the mined block with the private key of the miner.
+//The public key must have been set before mining since it is part of the hash
+func (b *Block) Sign(key ed25519.PrivateKey) error {
+	hash, err := b.Hash()
+	if err != nil {
+		return err
+	}
+	b.Signature = ed25519.Sign(key, []byte(hash))
+	return nil
+}
+
+//VerifySignature checks that the block hash is signed by the private key of the block PublicKey
+func (b *Block) VerifySignature() error {
+	if len(b.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("block has no valid public key")
+	}
+	hash, err := b.Hash()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(b.PublicKey), []byte(hash), b.Signature) {
+		return errors.New("invalid block signature")
 	}
-	return false
+	return nil
 }
@@ -1,181 +1,333 @@
 package blockchain
 
 import (
+	"bytes"
+	"crypto/ed25519"
 	"errors"
 	"fmt"
 	"log"
-	"math/rand"
+	"math/big"
 	"sync"
+
+	"github.com/KostasAronis/go-rfs/hashing"
 )
 
 /*
 	blockchain
 	TODO: inser
 */
-//Blocktree
+//BlockTreeNode a block of the tree linked with its parent and children
 type BlockTreeNode struct {
 	Block    *Block
-	Children []*Block
+	Hash     string
+	Parent   *BlockTreeNode
+	Children []*BlockTreeNode
+	//Height the number of blocks between this node and the genesis block
+	Height int
+	//Work the cumulative expected work of the chain ending on this node
+	Work *big.Int
+	//DifficultyShift the number of bits added to the configured difficulty of the block by retargeting, see shiftAfter
+	DifficultyShift int
 }
 
+//BlockTree stores every known block indexed by hash and keeps track of the tip of the heaviest chain (see heavier).
+//Blocks holds the blocks in insertion order and is the only part that gets serialized, Init rebuilds the index from it.
 type BlockTree struct {
 	m           *sync.RWMutex
 	GenesisNode *Block
 	Blocks      []*Block
 	OpDiff      int
 	NoopDiff    int
+	//RetargetInterval the number of blocks between difficulty adjustments, retargeting is disabled if less than 2
+	RetargetInterval int
+	//TargetBlockTime Time in milliseconds, the block interval the difficulty adjustments aim for
+	TargetBlockTime int
+	//MaxTimeDrift Time in milliseconds a block timestamp may be ahead of the local clock, see checkTimestamp
+	MaxTimeDrift int
+	//HashAlgorithm the hash function every block of the tree must be hashed with
+	HashAlgorithm hashing.Algorithm
+	nodes         map[string]*BlockTreeNode
+	tip           *BlockTreeNode
+	//minerKeys the allowed public key of every known miner, see SetMinerKeys
+	minerKeys map[string]ed25519.PublicKey
+	//consensus seals and verifies the blocks of the tree, see SetConsensus
+	consensus Consensus
 }
 
-func (b *BlockTree) Init() {
+//Init initializes the tree index from the GenesisNode and the stored Blocks
+func (b *BlockTree) Init() error {
 	b.m = &sync.RWMutex{}
-}
-
-func (b *BlockTree) GetBlockByHash(hash string) *Block {
-	for _, block := range b.Blocks {
-		h, err := block.ComputeHash()
+	if b.consensus == nil {
+		b.consensus = NewProofOfWork(b, 1)
+	}
+	genesisHash, err := b.GenesisNode.Hash()
+	if err != nil {
+		return err
+	}
+	genesis := &BlockTreeNode{
+		Block:    b.GenesisNode,
+		Hash:     genesisHash,
+		Children: []*BlockTreeNode{},
+		Height:   0,
+		Work:     big.NewInt(0),
+	}
+	b.nodes = map[string]*BlockTreeNode{genesisHash: genesis}
+	b.tip = genesis
+	blocks := b.Blocks
+	b.Blocks = []*Block{b.GenesisNode}
+	for _, block := range blocks {
+		hash, err := block.Hash()
 		if err != nil {
-			panic(err)
+			return err
 		}
-		if h == hash {
-			return block
+		if hash == genesisHash {
+			continue
+		}
+		if _, err := b.insert(block, hash); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (b *BlockTree) GetLastBlock() *Block {
+//SetMinerKeys restricts the blocks accepted by the tree to the miners with the given ids, signed by the given keys.
+//With no keys set every block with a valid signature is accepted and its MinerID is only a label, not an identity
+func (b *BlockTree) SetMinerKeys(keys map[string]ed25519.PublicKey) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.minerKeys = keys
+}
+
+func (b *BlockTree) GetBlockByHash(hash string) *Block {
 	b.m.RLock()
 	defer b.m.RUnlock()
-	longestChain := b.getLongestChain()
-	if len(longestChain) > 0 {
-		return longestChain[len(longestChain)-1]
+	node, ok := b.nodes[hash]
+	if !ok {
+		return nil
 	}
-	return b.GenesisNode
+	return node.Block
+}
+
+//GetNode returns the tree node of the block with the given hash or nil if it is unknown
+func (b *BlockTree) GetNode(hash string) *BlockTreeNode {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	return b.nodes[hash]
+}
+
+//GetLastBlock returns the tip of the heaviest chain
+func (b *BlockTree) GetLastBlock() *Block {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	return b.tip.Block
+}
+
+//GetLastNode returns the tree node of the tip of the heaviest chain
+func (b *BlockTree) GetLastNode() *BlockTreeNode {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	return b.tip
+}
+
+//GetLongestChain returns the blocks of the longest chain, starting from the genesis block
+func (b *BlockTree) GetLongestChain() []*Block {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	return b.getLongestChain()
 }
 
 func (b *BlockTree) AppendBlock(block *Block) error {
 	b.m.Lock()
 	defer b.m.Unlock()
-	valid, err := b.validNode(block, 1)
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+	if _, exists := b.nodes[hash]; exists {
+		return nil
+	}
+	valid, err := b.validNode(block, hash)
 	if err != nil {
 		return err
 	}
 	if !valid {
 		return errors.New("invalid block")
 	}
-	for _, existingBlock := range b.Blocks {
-		hash, err := block.ComputeHash()
-		if err != nil {
-			return err
-		}
-		eHash, err := existingBlock.ComputeHash()
-		if err != nil {
-			return err
-		}
-		if eHash == hash {
-			return nil
-		}
+	_, err = b.insert(block, hash)
+	return err
+}
+
+//insert links the block under its parent and moves the tip if the new chain is heavier
+func (b *BlockTree) insert(block *Block, hash string) (*BlockTreeNode, error) {
+	parent, ok := b.nodes[block.PrevHash]
+	if !ok {
+		return nil, fmt.Errorf("PrevHash %s not found", block.PrevHash)
+	}
+	node := &BlockTreeNode{
+		Block:           block,
+		Hash:            hash,
+		Parent:          parent,
+		Children:        []*BlockTreeNode{},
+		Height:          parent.Height + 1,
+		Work:            new(big.Int).Add(parent.Work, blockWork(b.consensus.Difficulty(parent, block.IsOp))),
+		DifficultyShift: b.shiftAfter(parent),
+	}
+	if node.DifficultyShift != parent.DifficultyShift {
+		log.Printf("retargeted difficulty at height %d: shift %d bits", node.Height, node.DifficultyShift)
 	}
+	parent.Children = append(parent.Children, node)
+	b.nodes[hash] = node
 	b.Blocks = append(b.Blocks, block)
-	return nil
+	if heavier(node, b.tip) {
+		b.tip = node
+	}
+	return node, nil
+}
+
+//heavier is the fork choice rule: the longest chain wins, then the chain with the most cumulative work and
+//remaining ties are broken in favour of the lowest tip hash so that every miner picks the same chain.
+//Length goes first so that op blocks, which usually have a lower difficulty, do not always lose against noop blocks
+func heavier(a *BlockTreeNode, b *BlockTreeNode) bool {
+	if a.Height != b.Height {
+		return a.Height > b.Height
+	}
+	cmp := a.Work.Cmp(b.Work)
+	if cmp != 0 {
+		return cmp > 0
+	}
+	return a.Hash < b.Hash
+}
+
+//CommonAncestor returns the last node shared by the chains ending on a and b
+func CommonAncestor(a *BlockTreeNode, b *BlockTreeNode) *BlockTreeNode {
+	for a.Height > b.Height {
+		a = a.Parent
+	}
+	for b.Height > a.Height {
+		b = b.Parent
+	}
+	for a != b {
+		a = a.Parent
+		b = b.Parent
+	}
+	return a
 }
+
 func (b *BlockTree) getLongestChain() []*Block {
-	maxLength := 0
-	maxChain := [][]*Block{}
-	for _, chain := range b.generateChains() {
-		if len(chain) == maxLength {
-			maxChain = append(maxChain, chain)
-		}
-		if len(chain) > maxLength {
-			maxLength = len(chain)
-			maxChain = [][]*Block{chain}
-		}
+	chain := make([]*Block, b.tip.Height+1)
+	for node := b.tip; node != nil; node = node.Parent {
+		chain[node.Height] = node.Block
 	}
-	return maxChain[rand.Intn(len(maxChain))]
+	return chain
 }
 
-func (b *BlockTree) generateChains() [][]*Block {
-	chains := [][]*Block{}
-	genesisChain := []*Block{b.GenesisNode}
-	chains = append(chains, genesisChain)
-	for _, newBlock := range b.Blocks {
-		newHash, err := newBlock.ComputeHash()
-		if err != nil {
-			panic(err)
-		}
-		genHash, err := b.GenesisNode.ComputeHash()
-		if err != nil {
-			panic(err)
-		}
-		if newHash == genHash {
-			continue
-		}
-		added := false
-		for cIdx, chain := range chains {
-			for bIdx, block := range chain {
-				hash, err := block.ComputeHash()
-				if err != nil {
-					panic(err)
-				}
-				if hash == newBlock.PrevHash {
-					if len(chain) > bIdx+1 { //create a new chain
-						newChain := []*Block{}
-						copy(newChain, chain)
-						newChain = append(newChain, newBlock)
-						chains = append(chains, newChain)
-					} else {
-						chains[cIdx] = append(chain, newBlock)
-					}
-					added = true
-				}
-			}
-		}
-		if !added {
-			panic(fmt.Errorf("WTF PrevHash %s not found", newBlock.PrevHash))
+//blockWork the expected number of hashes needed to find a block of the given difficulty (2^difficulty)
+func blockWork(difficulty int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(difficulty))
+}
+
+//HashesAfter returns up to max hashes of the longest chain following fromHash, in chain order.
+//If fromHash is on another branch the hashes start after its common ancestor with the longest chain
+//and if it is unknown they start after the genesis block
+func (b *BlockTree) HashesAfter(fromHash string, max int) []string {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	start, ok := b.nodes[fromHash]
+	if !ok {
+		start = b.nodes[b.GenesisNode.hash]
+	}
+	start = CommonAncestor(start, b.tip)
+	count := b.tip.Height - start.Height
+	if count > max {
+		count = max
+	}
+	hashes := make([]string, count)
+	for node := b.tip; node.Height > start.Height; node = node.Parent {
+		if node.Height-start.Height <= count {
+			hashes[node.Height-start.Height-1] = node.Hash
 		}
 	}
-	return chains
+	return hashes
 }
 
-func (b *BlockTree) validNode(block *Block, backCheck int) (bool, error) {
-	hash, err := block.ComputeHash()
+//CheckPOW checks only the seal of the block (see Consensus), the block may not be linked to the tree
+func (b *BlockTree) CheckPOW(block *Block) error {
+	err := b.checkAlgorithm(block)
 	if err != nil {
-		return false, err
+		return err
+	}
+	b.m.RLock()
+	defer b.m.RUnlock()
+	return b.consensus.VerifySeal(block, b.nodes[block.PrevHash])
+}
+
+//CheckBlock checks the seal, the timestamp and the signature of the block and that its parent is already in the tree
+func (b *BlockTree) CheckBlock(block *Block) error {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	hash, err := block.Hash()
+	if err != nil {
+		return err
 	}
-	gHash, err := b.GenesisNode.ComputeHash()
+	valid, err := b.validNode(block, hash)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("invalid block")
+	}
+	return nil
+}
+
+//validNode checks the seal, the timestamp and the signature of the block and that its parent is already in the tree
+func (b *BlockTree) validNode(block *Block, hash string) (bool, error) {
+	err := b.checkAlgorithm(block)
 	if err != nil {
 		return false, err
 	}
-	if hash == gHash {
-		return true, nil
+	parent, ok := b.nodes[block.PrevHash]
+	if !ok {
+		log.Printf("NIL PREVIOUS BLOCK FROM MINER: %s WITH HASH: %s WITH PREV: %s", block.MinerID, hash, block.PrevHash)
+		return false, errors.New("NIL PREVIOUS BLOCK")
 	}
-	var diff int
-	if block.IsOp {
-		diff = b.OpDiff
-	} else {
-		diff = b.NoopDiff
+	err = b.consensus.VerifySeal(block, parent)
+	if err != nil {
+		return false, err
 	}
-	isValid, err := block.IsValid(diff)
+	err = b.checkTimestamp(block, parent)
 	if err != nil {
 		return false, err
 	}
-	if isValid {
-		if backCheck > 0 {
-			if block.PrevHash != "" {
-				prevBlock := b.GetBlockByHash(block.PrevHash)
-				if prevBlock == nil {
-					log.Printf("NIL PREVIOUS BLOCK FROM MINER: %s WITH HASH: %s WITH PREV: %s", block.MinerID, hash, block.PrevHash)
-					return false, errors.New("NIL PREVIOUS BLOCK")
-				}
-				if prevBlock.IsOp {
-					diff = b.OpDiff
-				} else {
-					diff = b.NoopDiff
-				}
-				return b.validNode(prevBlock, backCheck-1)
-			}
-		}
-		return true, nil
+	err = b.checkMiner(block)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+//checkAlgorithm checks that the block is hashed with the hash function of the tree
+func (b *BlockTree) checkAlgorithm(block *Block) error {
+	if block.HashAlgorithm != b.HashAlgorithm {
+		return fmt.Errorf("block hashed with %s instead of %s", block.HashAlgorithm, b.HashAlgorithm)
+	}
+	return nil
+}
+
+//checkMiner checks the signature of the block and that it belongs to an allowed miner
+func (b *BlockTree) checkMiner(block *Block) error {
+	err := block.VerifySignature()
+	if err != nil {
+		return err
+	}
+	if len(b.minerKeys) == 0 {
+		return nil
 	}
-	return false, nil
+	key, ok := b.minerKeys[block.MinerID]
+	if !ok {
+		return fmt.Errorf("unknown miner %s", block.MinerID)
+	}
+	if !bytes.Equal(key, block.PublicKey) {
+		return fmt.Errorf("block is not signed by the key of miner %s", block.MinerID)
+	}
+	return nil
 }
@@ -0,0 +1,256 @@
+package blockchain_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+)
+
+//The fixtures of the package tests. A block caches its hash the first time it is hashed, so test blocks start
+//as a blockTemplate, get all their final fields and only then are sealed with sealTestBlock or signed with signTestBlock
+
+//testKey a deterministic key for every miner id
+func testKey(minerID string) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte(minerID), ed25519.SeedSize)[:ed25519.SeedSize])
+}
+
+//initTestTree adds the genesis block to a configured tree and initializes it
+func initTestTree(t *testing.T, tree *blockchain.BlockTree) *blockchain.BlockTree {
+	genesis := &blockchain.Block{Version: blockchain.HeaderVersion, MinerID: "0"}
+	tree.GenesisNode = genesis
+	tree.Blocks = []*blockchain.Block{genesis}
+	err := tree.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+//newTestTree returns a tree with only the genesis block that takes no work
+func newTestTree(t *testing.T) *blockchain.BlockTree {
+	return initTestTree(t, &blockchain.BlockTree{})
+}
+
+//mustHash computes the hash of the block without caching it
+func mustHash(t *testing.T, block *blockchain.Block) string {
+	hash, err := block.ComputeHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+//blockTemplate returns the unsigned noop block of the miner on prev, one second after it
+func blockTemplate(t *testing.T, prev *blockchain.Block, minerID string) *blockchain.Block {
+	return &blockchain.Block{
+		Version:   blockchain.HeaderVersion,
+		PrevHash:  mustHash(t, prev),
+		MinerID:   minerID,
+		Timestamp: prev.Timestamp + int64(time.Second),
+		PublicKey: testKey(minerID).Public().(ed25519.PublicKey),
+	}
+}
+
+//signTestBlock signs the block with key, which also becomes the PublicKey of the block
+func signTestBlock(t *testing.T, block *blockchain.Block, key ed25519.PrivateKey) *blockchain.Block {
+	block.PublicKey = key.Public().(ed25519.PublicKey)
+	err := block.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+//sealTestBlock seals the block with the consensus of the tree and signs it with the key of its miner, without
+//appending it. Returns nil if stop is closed first
+func sealTestBlock(t *testing.T, tree *blockchain.BlockTree, block *blockchain.Block, stop chan struct{}) *blockchain.Block {
+	sealed := tree.Consensus().Seal(block, tree.GetNode(block.PrevHash), stop)
+	if sealed == nil {
+		return nil
+	}
+	return signTestBlock(t, sealed, testKey(sealed.MinerID))
+}
+
+//newTestBlock returns the block of the miner on prev signed with the key of the miner
+func newTestBlock(t *testing.T, prev *blockchain.Block, minerID string) *blockchain.Block {
+	return signTestBlock(t, blockTemplate(t, prev, minerID), testKey(minerID))
+}
+
+//appendTestBlock appends the block of the miner on prev to a tree that takes no work
+func appendTestBlock(t *testing.T, tree *blockchain.BlockTree, prev *blockchain.Block, minerID string) *blockchain.Block {
+	block := newTestBlock(t, prev, minerID)
+	err := tree.AppendBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestBlockTreeTip(t *testing.T) {
+	tree := newTestTree(t)
+	a1 := appendTestBlock(t, tree, tree.GenesisNode, "a")
+	a2 := appendTestBlock(t, tree, a1, "a")
+	if tree.GetLastBlock() != a2 {
+		t.Error("Tip should be the last block of the only chain")
+	}
+	b2 := appendTestBlock(t, tree, a1, "b")
+	b3 := appendTestBlock(t, tree, b2, "b")
+	if tree.GetLastBlock() != b3 {
+		t.Error("Tip should move to the longer fork")
+	}
+	if tree.GetLastNode().Height != 3 {
+		t.Errorf("Tip height should be 3, got %d", tree.GetLastNode().Height)
+	}
+	chain := tree.GetLongestChain()
+	if len(chain) != 4 || chain[0] != tree.GenesisNode || chain[1] != a1 || chain[2] != b2 || chain[3] != b3 {
+		t.Error("Longest chain should be genesis, a1, b2, b3")
+	}
+	a2Hash, _ := a2.Hash()
+	if tree.GetBlockByHash(a2Hash) != a2 {
+		t.Error("Blocks of shorter forks should still be found by hash")
+	}
+	err := tree.AppendBlock(&blockchain.Block{Version: blockchain.HeaderVersion, PrevHash: "unknown", MinerID: "c"})
+	if err == nil {
+		t.Error("Appending a block with unknown parent should return error")
+	}
+}
+
+func TestBlockTreeRestore(t *testing.T) {
+	tree := newTestTree(t)
+	a1 := appendTestBlock(t, tree, tree.GenesisNode, "a")
+	appendTestBlock(t, tree, a1, "a")
+	appendTestBlock(t, tree, a1, "b")
+	restored := &blockchain.BlockTree{
+		GenesisNode: tree.GenesisNode,
+		Blocks:      tree.Blocks,
+	}
+	err := restored.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Blocks) != 4 {
+		t.Errorf("Restored tree should contain 4 blocks, got %d", len(restored.Blocks))
+	}
+	if restored.GetLastNode().Hash != tree.GetLastNode().Hash {
+		t.Error("Restored tree should pick the same tip")
+	}
+}
+
+func TestBlockTreeForkChoice(t *testing.T) {
+	tree := newTestTree(t)
+	a1 := appendTestBlock(t, tree, tree.GenesisNode, "a")
+	b1 := appendTestBlock(t, tree, tree.GenesisNode, "b")
+	a1Hash, _ := a1.Hash()
+	b1Hash, _ := b1.Hash()
+	expected := a1
+	if b1Hash < a1Hash {
+		expected = b1
+	}
+	if tree.GetLastBlock() != expected {
+		t.Error("Equal work forks should be resolved in favour of the lowest hash")
+	}
+	other := newTestTree(t)
+	other.AppendBlock(b1)
+	other.AppendBlock(a1)
+	if other.GetLastBlock() != expected {
+		t.Error("Fork choice should not depend on the order blocks are received")
+	}
+	b2 := appendTestBlock(t, tree, b1, "b")
+	ancestor := blockchain.CommonAncestor(tree.GetNode(a1Hash), tree.GetLastNode())
+	if ancestor.Block != tree.GenesisNode {
+		t.Error("Common ancestor of a1 and b2 should be the genesis block")
+	}
+	if tree.GetLastBlock() != b2 {
+		t.Error("Tip should be the heaviest chain")
+	}
+}
+
+func TestBlockTreeHashesAfter(t *testing.T) {
+	tree := newTestTree(t)
+	a1 := appendTestBlock(t, tree, tree.GenesisNode, "a")
+	a2 := appendTestBlock(t, tree, a1, "a")
+	b2 := appendTestBlock(t, tree, a1, "b")
+	b3 := appendTestBlock(t, tree, b2, "b")
+	hash := func(block *blockchain.Block) string {
+		h, _ := block.Hash()
+		return h
+	}
+	equal := func(got []string, expected ...*blockchain.Block) bool {
+		if len(got) != len(expected) {
+			return false
+		}
+		for i := range got {
+			if got[i] != hash(expected[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	if !equal(tree.HashesAfter("unknown", 10), a1, b2, b3) {
+		t.Error("Unknown hashes should start after the genesis block")
+	}
+	if !equal(tree.HashesAfter(hash(a2), 10), b2, b3) {
+		t.Error("Hashes of another branch should start after the common ancestor")
+	}
+	if !equal(tree.HashesAfter(hash(a1), 1), b2) {
+		t.Error("Hashes should be limited to max")
+	}
+	if !equal(tree.HashesAfter(hash(b3), 10)) {
+		t.Error("There should be no hashes after the tip")
+	}
+}
+
+func TestBlockTreeSignatures(t *testing.T) {
+	tree := newTestTree(t)
+	unsigned := blockTemplate(t, tree.GenesisNode, "a")
+	if tree.AppendBlock(unsigned) == nil {
+		t.Error("Unsigned blocks should be rejected")
+	}
+	forged := blockTemplate(t, tree.GenesisNode, "a")
+	forged.Signature = ed25519.Sign(testKey("b"), []byte("forged"))
+	if tree.AppendBlock(forged) == nil {
+		t.Error("Blocks with invalid signature should be rejected")
+	}
+	tree.SetMinerKeys(map[string]ed25519.PublicKey{
+		"a": testKey("a").Public().(ed25519.PublicKey),
+	})
+	appendTestBlock(t, tree, tree.GenesisNode, "a")
+	impostor := signTestBlock(t, blockTemplate(t, tree.GenesisNode, "a"), testKey("b"))
+	if tree.AppendBlock(impostor) == nil {
+		t.Error("Blocks signed by another key than the one of the miner should be rejected")
+	}
+	unknown := newTestBlock(t, tree.GenesisNode, "c")
+	if tree.AppendBlock(unknown) == nil {
+		t.Error("Blocks of unknown miners should be rejected")
+	}
+}
+
+func TestBlockTreeLengthFirst(t *testing.T) {
+	tree := initTestTree(t, &blockchain.BlockTree{
+		OpDiff:   0,
+		NoopDiff: 6,
+	})
+	genesis := tree.GenesisNode
+	appendSealed := func(prev *blockchain.Block, minerID string, isOp bool) *blockchain.Block {
+		block := blockTemplate(t, prev, minerID)
+		block.IsOp = isOp
+		sealed := sealTestBlock(t, tree, block, make(chan struct{}))
+		err := tree.AppendBlock(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealed
+	}
+	noop := appendSealed(genesis, "a", false)
+	op1 := appendSealed(genesis, "b", true)
+	if tree.GetLastBlock() != noop {
+		t.Error("Between chains of equal length the one with the most work should win")
+	}
+	op2 := appendSealed(op1, "b", true)
+	if tree.GetLastBlock() != op2 {
+		t.Error("The longest chain should win even with less work, so that op blocks do not lose against noop blocks")
+	}
+}
@@ -0,0 +1,24 @@
+package blockchain
+
+//Consensus decides how blocks are sealed by their miners and which sealed blocks may extend the tree
+type Consensus interface {
+	//Difficulty returns the difficulty of an op or noop block extending parent, the work it adds to its chain
+	Difficulty(parent *BlockTreeNode, isOp bool) int
+	//Seal completes the block extending parent so that it passes VerifySeal, the miner signs it afterwards.
+	//Returns nil if stop is closed first
+	Seal(block *Block, parent *BlockTreeNode, stop chan struct{}) *Block
+	//VerifySeal checks the seal of a block extending parent. parent is nil if it is not in the tree yet,
+	//in which case only the checks that do not depend on it are done.
+	//It is called with the lock of the tree held, so it must not call the exported methods of the tree
+	VerifySeal(block *Block, parent *BlockTreeNode) error
+}
+
+//SetConsensus sets the consensus of the tree. It must be called before Init, until then the tree uses proof of work
+func (b *BlockTree) SetConsensus(consensus Consensus) {
+	b.consensus = consensus
+}
+
+//Consensus returns the consensus of the tree, see SetConsensus
+func (b *BlockTree) Consensus() Consensus {
+	return b.consensus
+}
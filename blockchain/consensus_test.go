@@ -0,0 +1,68 @@
+package blockchain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+)
+
+func TestProofOfWorkSeal(t *testing.T) {
+	tree := newTestTree(t)
+	tree.NoopDiff = 8
+	block := sealTestBlock(t, tree, blockTemplate(t, tree.GenesisNode, "a"), make(chan struct{}))
+	if valid, _ := block.IsValid(8); !valid {
+		t.Error("Sealed block should have the difficulty of the tree")
+	}
+	if err := tree.AppendBlock(block); err != nil {
+		t.Error(err)
+	}
+	stop := make(chan struct{})
+	close(stop)
+	tree.NoopDiff = 256
+	if sealTestBlock(t, tree, blockTemplate(t, block, "a"), stop) != nil {
+		t.Error("Stopped seal should return no block")
+	}
+}
+
+func TestProofOfAuthority(t *testing.T) {
+	tree := &blockchain.BlockTree{}
+	tree.SetConsensus(blockchain.NewProofOfAuthority([]string{"c", "a", "b"}, time.Millisecond))
+	initTestTree(t, tree)
+	genesis := tree.GenesisNode
+	stop := make(chan struct{})
+	//the blocks are sealed at the earliest time of their miner, the templates are set earlier
+	poaTemplate := func(prev *blockchain.Block, minerID string) *blockchain.Block {
+		block := blockTemplate(t, prev, minerID)
+		block.Timestamp = prev.Timestamp
+		return block
+	}
+	//b is in turn at height 1
+	b1 := sealTestBlock(t, tree, poaTemplate(genesis, "b"), stop)
+	if b1.Timestamp != genesis.Timestamp+int64(time.Millisecond) {
+		t.Error("In turn miner should seal one period after the parent")
+	}
+	if err := tree.AppendBlock(b1); err != nil {
+		t.Fatal(err)
+	}
+	//c is in turn at height 2, a is next
+	a2 := sealTestBlock(t, tree, poaTemplate(b1, "a"), stop)
+	if a2.Timestamp != b1.Timestamp+2*int64(time.Millisecond) {
+		t.Error("Out of turn miner should seal one period after the miner before it")
+	}
+	early := blockTemplate(t, b1, "a")
+	early.Timestamp = b1.Timestamp + int64(time.Millisecond)
+	if tree.AppendBlock(signTestBlock(t, early, testKey("a"))) == nil {
+		t.Error("Blocks sealed before the turn of their miner should be rejected")
+	}
+	if err := tree.AppendBlock(a2); err != nil {
+		t.Error(err)
+	}
+	if tree.CheckPOW(newTestBlock(t, a2, "d")) == nil {
+		t.Error("Blocks of miners outside the authorities should be rejected")
+	}
+	d, err := tree.NextDifficulty(mustHash(t, a2), true)
+	if err != nil || d != 0 {
+		t.Error("Proof of authority blocks should take no work")
+	}
+}
@@ -0,0 +1,90 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/KostasAronis/go-rfs/hashing"
+	"github.com/KostasAronis/go-rfs/merkle"
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+//HeaderVersion the version of the header encoding produced by Header, blocks of other versions are rejected
+const HeaderVersion = 2
+
+//nonceSize the size of the nonce at the end of an encoded header
+const nonceSize = 4
+
+//BlockHeader returns the fields of the block covered by its hash
+func (b *Block) BlockHeader() *rfslib.BlockHeader {
+	return &rfslib.BlockHeader{
+		Version:       b.Version,
+		HashAlgorithm: b.HashAlgorithm,
+		PrevHash:      b.PrevHash,
+		MinerID:       b.MinerID,
+		PublicKey:     b.PublicKey,
+		IsOp:          b.IsOp,
+		OpsRoot:       b.OpsRoot(),
+		Timestamp:     b.Timestamp,
+		Nonce:         b.Nonce,
+	}
+}
+
+//Header returns the canonical binary encoding of the block header, the only input of the block hash.
+//See rfslib.BlockHeader.Bytes for the encoding
+func (b *Block) Header() []byte {
+	return b.BlockHeader().Bytes()
+}
+
+//opLeaves returns the encoded ops of the block, the leaves of its ops merkle tree
+func (b *Block) opLeaves() [][]byte {
+	leaves := make([][]byte, len(b.Ops))
+	for i, op := range b.Ops {
+		leaves[i] = op.Bytes()
+	}
+	return leaves
+}
+
+//OpsRoot returns the merkle root of the encoded ops of the block, see OpRecord.Bytes
+func (b *Block) OpsRoot() []byte {
+	return merkle.Root(b.opLeaves())
+}
+
+//OpProof returns the proof that the op with the given id is included in the block, see OpRecord.ID
+func (b *Block) OpProof(id string) (*rfslib.OpProof, error) {
+	hash, err := b.Hash()
+	if err != nil {
+		return nil, err
+	}
+	for i, op := range b.Ops {
+		if op.ID() != id {
+			continue
+		}
+		return &rfslib.OpProof{
+			BlockHash: hash,
+			Header:    *b.BlockHeader(),
+			Op:        *op.BlockOp(),
+			Path:      merkle.Proof(b.opLeaves(), i),
+		}, nil
+	}
+	return nil, fmt.Errorf("op %s is not in block %s", id, hash)
+}
+
+//SetHeaderNonce replaces the nonce of a header returned by Header
+func SetHeaderNonce(header []byte, nonce uint32) {
+	binary.BigEndian.PutUint32(header[len(header)-nonceSize:], nonce)
+}
+
+//HashHeader returns the hex encoded hash of an encoded header with the given algorithm
+func HashHeader(algorithm hashing.Algorithm, header []byte) string {
+	return hex.EncodeToString(algorithm.Sum(header))
+}
+
+//checkVersion returns an error for blocks with a header version or hash algorithm this miner can not encode
+func (b *Block) checkVersion() error {
+	if b.Version != HeaderVersion {
+		return fmt.Errorf("unsupported block version %d", b.Version)
+	}
+	return b.HashAlgorithm.Check()
+}
@@ -0,0 +1,127 @@
+package blockchain_test
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/hashing"
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+func TestBlockHeaderHash(t *testing.T) {
+	genesis := newTestTree(t).GenesisNode
+	//the signature is not part of the header
+	if mustHash(t, newTestBlock(t, genesis, "a")) != mustHash(t, blockTemplate(t, genesis, "a")) {
+		t.Error("Hash should not depend on the block signature")
+	}
+	//the fields of the unsigned template are changed one at a time, checking the hash computed every time
+	block := blockTemplate(t, genesis, "a")
+	header := block.Header()
+	block.Nonce = 7
+	blockchain.SetHeaderNonce(header, 7)
+	if blockchain.HashHeader(block.HashAlgorithm, header) != mustHash(t, block) {
+		t.Error("Replacing the nonce of an encoded header should match encoding the block with that nonce")
+	}
+	noOps := mustHash(t, block)
+	record := rfslib.Record{}
+	record.FromString("record")
+	block.Ops = []*blockchain.OpRecord{{OpType: blockchain.AppendRec, Filename: "file", Record: &record}}
+	withOp := mustHash(t, block)
+	if withOp == noOps {
+		t.Error("Hash should depend on the ops of the block")
+	}
+	tampered := rfslib.Record{}
+	tampered.FromString("tampered")
+	block.Ops[0].Record = &tampered
+	if mustHash(t, block) == withOp {
+		t.Error("Hash should depend on the records of the ops")
+	}
+	block.Version = blockchain.HeaderVersion + 1
+	if _, err := block.ComputeHash(); err == nil {
+		t.Error("Blocks with unknown header versions should not be hashed")
+	}
+}
+
+func TestOpProof(t *testing.T) {
+	key := testKey("client")
+	block := &blockchain.Block{Version: blockchain.HeaderVersion, MinerID: "a", IsOp: true}
+	for i := 0; i < 3; i++ {
+		record := rfslib.Record{}
+		record.FromString(fmt.Sprintf("record %d", i))
+		op := &blockchain.OpRecord{
+			OpType:    blockchain.AppendRec,
+			MinerID:   "a",
+			Filename:  "file",
+			Record:    &record,
+			UUID:      fmt.Sprintf("uuid %d", i),
+			Timestamp: time.Unix(0, int64(i)),
+			ClientKey: key.Public().(ed25519.PublicKey),
+		}
+		op.ClientSignature = op.SignedOp().Sign(key)
+		block.Ops = append(block.Ops, op)
+	}
+	hash, err := block.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, op := range block.Ops {
+		proof, err := block.OpProof(op.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		//proofs are sent to clients as json
+		proofBytes, err := json.Marshal(proof)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := &rfslib.OpProof{}
+		err = json.Unmarshal(proofBytes, received)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := rfslib.VerifyOpProof(received, hash); err != nil {
+			t.Error(err)
+		}
+		if *received.Op.Record != *op.Record {
+			t.Error("Proof should contain the record of the op")
+		}
+	}
+	proof, _ := block.OpProof(block.Ops[1].ID())
+	if rfslib.VerifyOpProof(proof, "other") == nil {
+		t.Error("Proofs should not verify against another block hash")
+	}
+	tampered := rfslib.Record{}
+	tampered.FromString("tampered")
+	proof.Op.Record = &tampered
+	if rfslib.VerifyOpProof(proof, hash) == nil {
+		t.Error("Proofs of a modified op should not verify")
+	}
+	if _, err := block.OpProof("missing"); err == nil {
+		t.Error("Ops not in the block should have no proof")
+	}
+	if _, err := block.OpProof(blockchain.OpID(testKey("other").Public().(ed25519.PublicKey), "uuid 1")); err == nil {
+		t.Error("The uuid of an op should not identify the ops of other clients")
+	}
+}
+
+func TestHashAlgorithm(t *testing.T) {
+	tree := newTestTree(t)
+	md5Block := blockTemplate(t, tree.GenesisNode, "a")
+	shaBlock := blockTemplate(t, tree.GenesisNode, "a")
+	shaBlock.HashAlgorithm = hashing.SHA256
+	md5Hash := mustHash(t, md5Block)
+	sha256Hash := mustHash(t, shaBlock)
+	if len(md5Hash) != 32 || len(sha256Hash) != 64 {
+		t.Error("Hashes should be hex encoded digests of the block hash algorithm")
+	}
+	if tree.AppendBlock(signTestBlock(t, shaBlock, testKey("a"))) == nil {
+		t.Error("Blocks hashed with another algorithm than the tree should be rejected")
+	}
+	if !blockchain.ValidPOW("0fff", 4) || blockchain.ValidPOW("0fff", 5) || !blockchain.ValidPOW("007f", 9) {
+		t.Error("Difficulty should count the leading zero bits of the hash")
+	}
+}
@@ -5,16 +5,21 @@ import (
 	"testing"
 
 	"github.com/KostasAronis/go-rfs/blockchain/miner"
+	"github.com/KostasAronis/go-rfs/minerconfig"
 )
 
 func TestMiner(t *testing.T) {
-	minerConfig := miner.Config{
-		MinerID:             "1",
-		PeerMinersAddrs:     []string{"127.0.0.1:9002", "127.0.0.1:9003", "127.0.0.1:9004"},
+	minerConfig := minerconfig.Config{
+		MinerID: "1",
+		PeerMiners: []minerconfig.PeerMiner{
+			{ID: "2", Addr: "127.0.0.1:9002"},
+			{ID: "3", Addr: "127.0.0.1:9003"},
+			{ID: "4", Addr: "127.0.0.1:9004"},
+		},
 		IncomingClientsAddr: "127.0.0.1:8001",
 		IncomingMinersAddr:  "127.0.0.1:9001",
 		OutgoingMinersIP:    "127.0.0.1:10001",
-		CommonMinerConfig: miner.CommonMinerConfig{
+		CommonMinerConfig: minerconfig.CommonMinerConfig{
 			GenOpBlockTimeout:      500,
 			MinedCoinsPerOpBlock:   3,
 			MinedCoinsPerNoOpBlock: 2,
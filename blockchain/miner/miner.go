@@ -7,6 +7,8 @@ package miner
 */
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
@@ -17,15 +19,15 @@ import (
 	"github.com/DistributedClocks/GoVector/govec"
 	"github.com/KostasAronis/go-rfs/blockchain"
 	"github.com/KostasAronis/go-rfs/blockchainfs"
+	"github.com/KostasAronis/go-rfs/filesystem"
 	"github.com/KostasAronis/go-rfs/minerconfig"
 	"github.com/KostasAronis/go-rfs/rfslib"
 	"github.com/KostasAronis/go-rfs/serialization"
 	"github.com/KostasAronis/go-rfs/tcp"
-	"github.com/KostasAronis/go-rfs/uuid"
 )
 
-const maxNonce uint32 = 4294967295
-const goRoutineCount = 5
+//peerTimeout how long a request to a peer miner may take before it is given up
+const peerTimeout = 30 * time.Second
 
 //Miner describes the main miner entity of the network
 type Miner struct {
@@ -41,6 +43,7 @@ type Miner struct {
 	blockToFlood      chan *blockchain.Block
 	blockFlooded      chan bool
 	opToFlood         chan *blockchain.OpRecord
+	syncRequests      chan string
 	exitError         chan error
 }
 
@@ -74,6 +77,7 @@ func New(minerConfig *minerconfig.Config) *Miner {
 		blockToFlood: blockToFlood,
 		blockFlooded: blockFlooded,
 		opToFlood:    make(chan *blockchain.OpRecord),
+		syncRequests: make(chan string, 10),
 	}
 	for _, peer := range m.minerConfig.PeerMiners {
 		c := tcp.Client{
@@ -82,6 +86,8 @@ func New(minerConfig *minerconfig.Config) *Miner {
 			TargetID:    peer.ID,
 			TargetAddr:  peer.Addr,
 			GovecLogger: govecLogger,
+			Timeout:     peerTimeout,
+			Pushes:      make(chan *tcp.Msg, 10),
 		}
 		m.peers = append(m.peers, &c)
 	}
@@ -100,6 +106,10 @@ func (m *Miner) Start() error {
 	if err != nil {
 		return err
 	}
+	for _, peer := range m.peers {
+		go m.listenToPeer(peer)
+	}
+	go m.syncLoop()
 	err = <-m.exitError
 	return err
 }
@@ -117,18 +127,16 @@ func (m *Miner) startListeningTCP() error {
 		return err
 	}
 	log.Printf("client server listening on: %s\n", m.minerConfig.IncomingClientsAddr)
+	//messages from peer miners are handled one at a time and in the order they arrive
+	//so that blocks flooded over the same connection are not reordered
 	go func() {
-		for {
-			select {
-			case conn, ok := <-blockChainConnections:
-				if ok {
-					go m.handleBlockchainConn(conn)
-				}
-			case conn, ok := <-clientConnections:
-				if ok {
-					go m.handleClientConn(conn)
-				}
-			}
+		for conn := range blockChainConnections {
+			m.handleBlockchainConn(conn)
+		}
+	}()
+	go func() {
+		for conn := range clientConnections {
+			go m.handleClientConn(conn)
 		}
 	}()
 	return nil
@@ -137,7 +145,51 @@ func (m *Miner) startListeningTCP() error {
 func (m *Miner) handleBlockchainConn(conn *tcp.Connection) {
 	msg := <-conn.Recv
 	log.Printf("blockchain server recv: %s", msg.MSGType)
-	conn.Send <- m.handleBlockchainMsg(msg)
+	switch msg.MSGType {
+	case tcp.GetTip, tcp.GetHeaders, tcp.GetBlocks:
+		//read only msgs do not have to wait for the blocks before them
+		go func() {
+			conn.Send <- m.handleSyncMsg(msg)
+		}()
+	default:
+		conn.Send <- m.handleBlockchainMsg(msg)
+	}
+}
+
+//listenToPeer handles the blocks pushed by the peer, one at a time and in the order they arrive
+func (m *Miner) listenToPeer(peer *tcp.Client) {
+	err := peer.Listen()
+	if err != nil {
+		log.Printf("could not connect to peer %s, retrying in the background: %s", peer.TargetID, err.Error())
+	}
+	for push := range peer.Pushes {
+		switch push.MSGType {
+		case tcp.Block:
+			//[]byte is encoded as a base64 string in the json pushes
+			encoded, ok := push.Payload.(string)
+			if !ok {
+				log.Printf("incorrect block pushed by peer %s", peer.TargetID)
+				continue
+			}
+			blockBytes, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil {
+				log.Printf("incorrect block pushed by peer %s: %s", peer.TargetID, err.Error())
+				continue
+			}
+			msg := *push
+			msg.ClientID = peer.TargetID
+			msg.Payload = blockBytes
+			res := m.handleBlockchainMsg(&msg)
+			if res.MSGType == tcp.Error {
+				log.Printf("rejected block pushed by peer %s: %v", peer.TargetID, res.Payload)
+			}
+		case tcp.Error:
+			//the blocks pushed until the client reconnects are lost, the next one is an orphan and triggers a sync
+			log.Printf("lost connection to peer %s: %v", peer.TargetID, push.Payload)
+		default:
+			log.Printf("unexpected push %s from peer %s", push.MSGType, peer.TargetID)
+		}
+	}
 }
 
 func (m *Miner) handleBlockchainMsg(msg *tcp.Msg) *tcp.Msg {
@@ -152,24 +204,37 @@ func (m *Miner) handleBlockchainMsg(msg *tcp.Msg) *tcp.Msg {
 		if err != nil {
 			return errorPayload(err)
 		}
-		h, err := block.ComputeHash()
+		h, err := block.Hash()
 		if err != nil {
 			return errorPayload(err)
 		}
 		if m.blockchainfs.BlockExists(h) {
 			return msg
 		}
-		err = m.blockchainfs.AddExternalBlock(block)
+		err = m.addPeerBlock(block, msg.ClientID)
 		if err != nil {
 			return errorPayload(err)
 		}
 		return msg
-	case tcp.CreateFile, tcp.AppendRec:
-	default:
-		return &tcp.Msg{
-			MSGType: tcp.Error,
-			Payload: "NIY",
+	case tcp.CreateFile, tcp.AppendRec, tcp.DeleteFile, tcp.RenameFile:
+		opBytes, ok := msg.Payload.([]byte)
+		if !ok {
+			return incorrectPayload()
 		}
+		op, err := serialization.DecodeToOp(opBytes)
+		if err != nil {
+			return errorPayload(err)
+		}
+		err = m.blockchainfs.AddExternalOp(op)
+		if err == blockchainfs.ErrDuplicateOp {
+			return msg
+		}
+		if err != nil {
+			return errorPayload(err)
+		}
+		//gossip the new op to the rest of the network
+		m.opToFlood <- op
+		return msg
 	}
 	return &tcp.Msg{
 		MSGType: tcp.Error,
@@ -180,7 +245,7 @@ func (m *Miner) handleBlockchainMsg(msg *tcp.Msg) *tcp.Msg {
 func (m *Miner) handleClientConn(conn *tcp.Connection) {
 	msg := <-conn.Recv
 	log.Printf("client server recv: %s", msg.MSGType)
-	conn.Send <- m.handleClientMsg(msg)
+	conn.Send <- m.handleClientMsg(msg, conn.Closed)
 }
 func incorrectPayload() *tcp.Msg {
 	return &tcp.Msg{
@@ -194,48 +259,102 @@ func errorPayload(err error) *tcp.Msg {
 		Payload: err.Error(),
 	}
 }
-func (m *Miner) handleClientMsg(msg *tcp.Msg) *tcp.Msg {
+
+//clientErrorPayload the Error response to an rfs client, which carries the code of the typed RFS errors
+func clientErrorPayload(err error) *tcp.Msg {
+	return &tcp.Msg{
+		MSGType: tcp.Error,
+		Payload: rfslib.ErrorPayload(err),
+	}
+}
+
+//handleClientMsg handles a message from an rfs client. Blocking requests are abandoned when cancel is closed
+func (m *Miner) handleClientMsg(msg *tcp.Msg, cancel <-chan struct{}) *tcp.Msg {
 	switch msg.MSGType {
-	case tcp.CreateFile, tcp.AppendRec:
+	case tcp.Ping:
+		return &tcp.Msg{
+			MSGType: tcp.Ping,
+		}
+
+	case tcp.CreateFile, tcp.AppendRec, tcp.DeleteFile, tcp.RenameFile:
 		optype := blockchain.OpType(msg.MSGType)
 		payload, ok := msg.Payload.(map[string]interface{})
 		if !ok {
 			return incorrectPayload()
 		}
-		filename, ok := payload["Filename"]
+		filename, ok := payload["Filename"].(string)
 		if !ok {
 			return incorrectPayload()
 		}
-		record, ok := payload["Record"]
+		var r *rfslib.Record
+		if optype == blockchain.AppendRec {
+			record, ok := payload["Record"]
+			if !ok {
+				return incorrectPayload()
+			}
+			r = &rfslib.Record{}
+			r.FromFloatArrayInterface(record)
+		}
+		newFilename := ""
+		if optype == blockchain.RenameFile {
+			newFilename, ok = payload["NewFilename"].(string)
+			if !ok || newFilename == "" {
+				return incorrectPayload()
+			}
+		}
+		//the uuid, timestamp and writers are chosen and signed by the client
+		uuid, ok := payload["UUID"].(string)
 		if !ok {
 			return incorrectPayload()
 		}
-		uuid, err := uuid.New()
-		if err != nil {
-			return &tcp.Msg{
-				MSGType: tcp.Error,
-				Payload: err.Error(),
-			}
+		timestamp, ok := toInt(payload["Timestamp"])
+		if !ok {
+			return incorrectPayload()
+		}
+		clientKey, ok := payload["PublicKey"].([]byte)
+		if !ok {
+			return incorrectPayload()
+		}
+		signature, ok := payload["Signature"].([]byte)
+		if !ok {
+			return incorrectPayload()
+		}
+		writers, ok := toByteSlices(payload["Writers"])
+		if !ok {
+			return incorrectPayload()
 		}
-		r := rfslib.Record{}
-		r.FromFloatArrayInterface(record)
 		op := blockchain.OpRecord{
-			OpType:    optype,
-			MinerID:   m.minerConfig.MinerID,
-			Filename:  filename.(string),
-			Record:    &r,
-			UUID:      uuid,
-			Timestamp: time.Now().UTC(),
-		}
-		op.OpType = optype
-		blockChan, err := m.blockchainfs.TryStageOp(&op)
+			OpType:          optype,
+			MinerID:         m.minerConfig.MinerID,
+			Filename:        filename,
+			NewFilename:     newFilename,
+			Record:          r,
+			UUID:            uuid,
+			Timestamp:       time.Unix(0, int64(timestamp)).UTC(),
+			ClientKey:       clientKey,
+			Writers:         writers,
+			ClientSignature: signature,
+		}
+		resultChan, err := m.blockchainfs.TryStageOp(&op)
 		if err != nil {
+			return clientErrorPayload(err)
+		}
+		m.opToFlood <- &op
+		var result *blockchainfs.OpResult
+		select {
+		case result = <-resultChan:
+		case <-cancel:
+			return clientErrorPayload(fmt.Errorf("client disconnected before op %s was confirmed", op.UUID))
+		}
+		if result.Err != nil {
+			return clientErrorPayload(result.Err)
+		}
+		if optype == blockchain.AppendRec {
 			return &tcp.Msg{
-				MSGType: tcp.Error,
-				Payload: err.Error(),
+				MSGType: msg.MSGType,
+				Payload: result.Index,
 			}
 		}
-		<-blockChan
 		return &tcp.Msg{
 			MSGType: msg.MSGType,
 			Payload: "OpAdded",
@@ -282,23 +401,49 @@ func (m *Miner) handleClientMsg(msg *tcp.Msg) *tcp.Msg {
 		}
 		resPayload, err := m.blockchainfs.FS.TotalRecords(filename.(string))
 		if err != nil {
-			return &tcp.Msg{
-				MSGType: tcp.Error,
-				Payload: err.Error(),
-			}
+			return clientErrorPayload(err)
 		}
 		return &tcp.Msg{
 			MSGType: msg.MSGType,
 			Payload: resPayload,
 		}
 
-		// Read record operation on the rfs, no blocking
+	case tcp.GetOpProof:
+		payload, ok := msg.Payload.(map[string]interface{})
+		if !ok {
+			return incorrectPayload()
+		}
+		uuid, ok := payload["UUID"].(string)
+		if !ok {
+			return incorrectPayload()
+		}
+		clientKey, ok := payload["PublicKey"].([]byte)
+		if !ok {
+			return incorrectPayload()
+		}
+		proof, err := m.blockchainfs.OpProof(clientKey, uuid)
+		if err != nil {
+			return clientErrorPayload(err)
+		}
+		//sent encoded since the timestamps of the proof do not fit in the float64 of generic json numbers
+		proofBytes, err := json.Marshal(proof)
+		if err != nil {
+			return clientErrorPayload(err)
+		}
+		return &tcp.Msg{
+			MSGType: msg.MSGType,
+			Payload: proofBytes,
+		}
+
+		// Read record operation on the rfs. The request is answered at once and the records are pushed to the client
+		// with the Wait id of the request once they are confirmed on the longest chain, see pushRecords.
+		// With a Height the records are read as they were at that height and sent in the response
 	case tcp.ReadRec:
 		payload, ok := msg.Payload.(map[string]interface{})
 		if !ok {
 			return incorrectPayload()
 		}
-		filename, ok := payload["Filename"]
+		filename, ok := payload["Filename"].(string)
 		if !ok {
 			return incorrectPayload()
 		}
@@ -310,18 +455,37 @@ func (m *Miner) handleClientMsg(msg *tcp.Msg) *tcp.Msg {
 		if !ok {
 			return incorrectPayload()
 		}
-		indexes := []int8{}
+		indexes := []int{}
 		for _, v := range indInterfaces {
-			indexes = append(indexes, v.(int8))
+			index, ok := toInt(v)
+			if !ok {
+				return incorrectPayload()
+			}
+			indexes = append(indexes, index)
+		}
+		height := -1
+		if h, ok := payload["Height"]; ok {
+			height, ok = toInt(h)
+			if !ok || height < 0 {
+				return incorrectPayload()
+			}
+		}
+		if height < 0 {
+			wait, ok := payload["Wait"].(string)
+			if !ok {
+				return incorrectPayload()
+			}
+			go m.pushRecords(msg.ClientID, wait, filename, indexes, cancel)
+			return &tcp.Msg{
+				MSGType: msg.MSGType,
+				Payload: "Waiting",
+			}
 		}
 		resPayload := []*rfslib.Record{}
-		for index := range indexes {
-			record, err := m.blockchainfs.FS.ReadRecord(filename.(string), index)
+		for _, index := range indexes {
+			record, err := m.blockchainfs.ReadRecordAt(filename, index, height)
 			if err != nil {
-				return &tcp.Msg{
-					MSGType: tcp.Error,
-					Payload: err.Error(),
-				}
+				return clientErrorPayload(err)
 			}
 			resPayload = append(resPayload, record)
 		}
@@ -343,6 +507,36 @@ func (m *Miner) handleClientMsg(msg *tcp.Msg) *tcp.Msg {
 	}
 }
 
+//pushRecords waits for the records of a ReadRec to be confirmed and pushes them, or the error of the read, to the client.
+//Nothing is pushed if the client disconnects before, cancel is closed then
+func (m *Miner) pushRecords(clientID string, wait string, filename string, indexes []int, cancel <-chan struct{}) {
+	payload := map[string]interface{}{
+		"Wait": wait,
+	}
+	records := []*rfslib.Record{}
+	for _, index := range indexes {
+		record, err := m.blockchainfs.WaitRecord(filename, index, cancel)
+		if err == filesystem.ErrWaitCancelled {
+			return
+		}
+		if err != nil {
+			payload["Error"] = rfslib.ErrorPayload(err)
+			break
+		}
+		records = append(records, record)
+	}
+	if _, failed := payload["Error"]; !failed {
+		payload["Records"] = records
+	}
+	err := m.clientServer.Push(clientID, &tcp.Msg{
+		MSGType: tcp.ReadRec,
+		Payload: payload,
+	})
+	if err != nil {
+		log.Printf("could not push records of %s to %s: %s", filename, clientID, err.Error())
+	}
+}
+
 func (m *Miner) floodToPeers() {
 	log.Println("listening for flood msg")
 	for {
@@ -360,14 +554,10 @@ func (m *Miner) floodToPeers() {
 	}
 }
 
+//floodBlock pushes the block to the peers listening on the blockchain server, see listenToPeer.
+//Peers that are not connected get the block by syncing once they receive the next one
 func (m *Miner) floodBlock(block *blockchain.Block) {
 	log.Println("flooding block to peers")
-	clientsToSend := []*tcp.Client{}
-	for _, c := range m.peers {
-		if c.TargetID != block.MinerID {
-			clientsToSend = append(clientsToSend, c)
-		}
-	}
 	blockBytes, err := serialization.EncodeToBytes(block)
 	if err != nil {
 		log.Printf("error in encoding: %s", err.Error())
@@ -378,25 +568,38 @@ func (m *Miner) floodBlock(block *blockchain.Block) {
 		MSGType:  tcp.Block,
 		Payload:  blockBytes,
 	}
-	err = m.flood(&msg, "block: prevhash: "+block.PrevHash, clientsToSend)
-	if err != nil {
-		log.Printf("error in flooding: %s", err.Error())
+	for _, c := range m.peers {
+		if c.TargetID == block.MinerID {
+			continue
+		}
+		go func(peerID string) {
+			log.Printf("push block to peer: %s ", peerID)
+			err := m.blockchainServer.Push(peerID, &msg)
+			if err != nil {
+				log.Printf("ERROR!: could not push block to peer: %s, %s", peerID, err.Error())
+			}
+		}(c.TargetID)
 	}
 }
 func (m *Miner) floodOp(op *blockchain.OpRecord) {
-	log.Println("flooding block to peers")
+	log.Println("flooding op to peers")
 	clientsToSend := []*tcp.Client{}
 	for _, c := range m.peers {
 		if c.TargetID != op.MinerID {
 			clientsToSend = append(clientsToSend, c)
 		}
 	}
+	opBytes, err := serialization.EncodeToBytes(op)
+	if err != nil {
+		log.Printf("error in encoding: %s", err.Error())
+		return
+	}
 	msg := tcp.Msg{
 		ClientID: m.minerConfig.MinerID,
 		MSGType:  tcp.MSGType(op.OpType),
-		Payload:  op,
+		Payload:  opBytes,
 	}
-	err := m.flood(&msg, op.OpType.String()+": "+op.Filename, clientsToSend)
+	err = m.flood(&msg, op.OpType.String()+": "+op.Filename, clientsToSend)
 	if err != nil {
 		log.Printf("error in flooding: %s", err.Error())
 	}
@@ -411,7 +614,11 @@ func (m *Miner) flood(msg *tcp.Msg, govecTxt string, peers []*tcp.Client) error
 		//wg.Add(1)
 		go func(c *tcp.Client) {
 			log.Printf("flood to peer: %s ", c.TargetID)
-			res := c.Send(msg, govecTxt)
+			res, err := c.Send(msg, govecTxt)
+			if err != nil {
+				log.Printf("ERROR!: could not reach peer: %s, %s", c.TargetID, err.Error())
+				return
+			}
 			log.Printf("got res from peer: %s, %s ", c.TargetID, res.MSGType.String())
 			if res.MSGType == tcp.Error {
 				log.Println("ERROR!: ", res.Payload)
@@ -428,6 +635,53 @@ func (m *Miner) flood(msg *tcp.Msg, govecTxt string, peers []*tcp.Client) error
 	return fmt.Errorf(strings.Join(errors, ", "))
 }
 
+//toInt converts any numeric value decoded from a msg payload to int
+func toInt(i interface{}) (int, bool) {
+	switch v := i.(type) {
+	case int8:
+		return int(v), true
+	case int16:
+		return int(v), true
+	case int32:
+		return int(v), true
+	case int64:
+		return int(v), true
+	case int:
+		return v, true
+	case uint8:
+		return int(v), true
+	case uint16:
+		return int(v), true
+	case uint32:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+//toByteSlices converts a list of byte slices decoded from a msg payload, a missing list is empty
+func toByteSlices(i interface{}) ([][]byte, bool) {
+	if i == nil {
+		return nil, true
+	}
+	arr, ok := i.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	slices := [][]byte{}
+	for _, v := range arr {
+		b, ok := v.([]byte)
+		if !ok {
+			return nil, false
+		}
+		slices = append(slices, b)
+	}
+	return slices, true
+}
+
 func getRecord(i interface{}) *rfslib.Record {
 	arr := i.([]interface{})
 	r := rfslib.Record{}
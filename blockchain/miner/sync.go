@@ -0,0 +1,263 @@
+package miner
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/blockchainfs"
+	"github.com/KostasAronis/go-rfs/serialization"
+	"github.com/KostasAronis/go-rfs/tcp"
+)
+
+//maxHeadersPerMsg the maximum number of hashes returned for a GetHeaders msg
+const maxHeadersPerMsg = 500
+
+//maxBlocksPerMsg the maximum number of blocks requested with a single GetBlocks msg
+const maxBlocksPerMsg = 20
+
+//syncLoop brings the chain up to date with every peer on startup and then with
+//the peers that send orphan blocks
+func (m *Miner) syncLoop() {
+	for _, peer := range m.peers {
+		m.trySync(peer)
+	}
+	for peerID := range m.syncRequests {
+		for _, peer := range m.peers {
+			if peer.TargetID == peerID {
+				m.trySync(peer)
+			}
+		}
+	}
+}
+
+//requestSync schedules a sync with the given peer, it never blocks
+func (m *Miner) requestSync(peerID string) {
+	select {
+	case m.syncRequests <- peerID:
+	default:
+	}
+}
+
+func (m *Miner) trySync(peer *tcp.Client) {
+	err := m.syncWith(peer)
+	if err != nil {
+		log.Printf("sync with peer %s failed: %s", peer.TargetID, err.Error())
+	}
+}
+
+//syncWith fetches and adds the blocks of the longest chain of the peer that are missing from ours
+func (m *Miner) syncWith(peer *tcp.Client) error {
+	tip, err := m.getTip(peer)
+	if err != nil {
+		return err
+	}
+	if m.blockchainfs.BlockExists(tip) {
+		return nil
+	}
+	log.Printf("syncing with peer %s", peer.TargetID)
+	from, _ := m.blockchainfs.Tip()
+	for {
+		hashes, err := m.getHeaders(peer, from)
+		if err != nil {
+			return err
+		}
+		if len(hashes) == 0 {
+			return nil
+		}
+		missing := []string{}
+		for _, hash := range hashes {
+			if !m.blockchainfs.BlockExists(hash) {
+				missing = append(missing, hash)
+			}
+		}
+		for start := 0; start < len(missing); start += maxBlocksPerMsg {
+			end := start + maxBlocksPerMsg
+			if end > len(missing) {
+				end = len(missing)
+			}
+			blocks, err := m.getBlocks(peer, missing[start:end])
+			if err != nil {
+				return err
+			}
+			for _, block := range blocks {
+				err = m.addPeerBlock(block, peer.TargetID)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if len(hashes) < maxHeadersPerMsg {
+			return nil
+		}
+		from = hashes[len(hashes)-1]
+	}
+}
+
+func (m *Miner) getTip(peer *tcp.Client) (string, error) {
+	res, err := m.request(peer, &tcp.Msg{MSGType: tcp.GetTip}, "GetTip")
+	if err != nil {
+		return "", err
+	}
+	payload, ok := res.(map[string]interface{})
+	if !ok {
+		return "", errors.New("Incorrect payload")
+	}
+	hash, ok := payload["Hash"].(string)
+	if !ok {
+		return "", errors.New("Incorrect payload")
+	}
+	return hash, nil
+}
+
+func (m *Miner) getHeaders(peer *tcp.Client, fromHash string) ([]string, error) {
+	msg := tcp.Msg{
+		MSGType: tcp.GetHeaders,
+		Payload: map[string]interface{}{
+			"FromHash": fromHash,
+		},
+	}
+	res, err := m.request(peer, &msg, "GetHeaders: "+fromHash)
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := res.([]interface{})
+	if !ok && res != nil {
+		return nil, errors.New("Incorrect payload")
+	}
+	hashes := []string{}
+	for _, h := range arr {
+		hash, ok := h.(string)
+		if !ok {
+			return nil, errors.New("Incorrect payload")
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+func (m *Miner) getBlocks(peer *tcp.Client, hashes []string) ([]*blockchain.Block, error) {
+	msg := tcp.Msg{
+		MSGType: tcp.GetBlocks,
+		Payload: map[string]interface{}{
+			"Hashes": hashes,
+		},
+	}
+	res, err := m.request(peer, &msg, fmt.Sprintf("GetBlocks: %d", len(hashes)))
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := res.([]interface{})
+	if !ok && res != nil {
+		return nil, errors.New("Incorrect payload")
+	}
+	blocks := []*blockchain.Block{}
+	for _, b := range arr {
+		//[]byte is encoded as a base64 string in the json responses
+		encoded, ok := b.(string)
+		if !ok {
+			return nil, errors.New("Incorrect payload")
+		}
+		blockBytes, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, err
+		}
+		block, err := serialization.DecodeToBlock(blockBytes)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, nil
+}
+
+//request sends msg to the peer and returns the payload of the response, or the error reported by the peer
+func (m *Miner) request(peer *tcp.Client, msg *tcp.Msg, govecTxt string) (interface{}, error) {
+	res, err := peer.Send(msg, govecTxt)
+	if err != nil {
+		return nil, err
+	}
+	if res.MSGType == tcp.Error {
+		errStr, _ := res.Payload.(string)
+		return nil, errors.New(errStr)
+	}
+	return res.Payload, nil
+}
+
+//handleSyncMsg answers the GetTip, GetHeaders and GetBlocks msgs of peers
+func (m *Miner) handleSyncMsg(msg *tcp.Msg) *tcp.Msg {
+	switch msg.MSGType {
+	case tcp.GetTip:
+		hash, height := m.blockchainfs.Tip()
+		return &tcp.Msg{
+			MSGType: msg.MSGType,
+			Payload: map[string]interface{}{
+				"Hash":   hash,
+				"Height": height,
+			},
+		}
+	case tcp.GetHeaders:
+		payload, ok := msg.Payload.(map[string]interface{})
+		if !ok {
+			return incorrectPayload()
+		}
+		fromHash, ok := payload["FromHash"].(string)
+		if !ok {
+			return incorrectPayload()
+		}
+		return &tcp.Msg{
+			MSGType: msg.MSGType,
+			Payload: m.blockchainfs.HashesAfter(fromHash, maxHeadersPerMsg),
+		}
+	case tcp.GetBlocks:
+		payload, ok := msg.Payload.(map[string]interface{})
+		if !ok {
+			return incorrectPayload()
+		}
+		hashes, ok := payload["Hashes"].([]interface{})
+		if !ok {
+			return incorrectPayload()
+		}
+		blocks := [][]byte{}
+		for _, h := range hashes {
+			hash, ok := h.(string)
+			if !ok {
+				return incorrectPayload()
+			}
+			block := m.blockchainfs.GetBlock(hash)
+			if block == nil {
+				continue
+			}
+			blockBytes, err := serialization.EncodeToBytes(block)
+			if err != nil {
+				return errorPayload(err)
+			}
+			blocks = append(blocks, blockBytes)
+		}
+		return &tcp.Msg{
+			MSGType: msg.MSGType,
+			Payload: blocks,
+		}
+	}
+	return incorrectPayload()
+}
+
+//addPeerBlock adds a block flooded or synced by the given peer. Both paths go through
+//BlockchainFS.AddExternalBlock, which serializes them. An orphan schedules a sync with the peer
+func (m *Miner) addPeerBlock(block *blockchain.Block, peerID string) error {
+	err := m.blockchainfs.AddExternalBlock(block)
+	if isOrphan(err) {
+		log.Println(err.Error())
+		m.requestSync(peerID)
+		return nil
+	}
+	return err
+}
+
+//isOrphan reports whether err was returned for a block whose ancestry is missing
+func isOrphan(err error) bool {
+	_, ok := err.(*blockchainfs.OrphanBlockError)
+	return ok
+}
@@ -1,6 +1,8 @@
 package blockchain
 
 import (
+	"encoding/hex"
+	"errors"
 	"time"
 
 	"github.com/KostasAronis/go-rfs/rfslib"
@@ -13,5 +15,61 @@ type OpRecord struct {
 	UUID      string
 	OpType    OpType
 	Filename  string
-	Record    *rfslib.Record
+	//NewFilename the new name of the file (RenameFile only)
+	NewFilename string
+	Record      *rfslib.Record
+	//ClientKey the ed25519 public key of the client that issued the op, the owner of the file for CreateFile ops
+	ClientKey []byte
+	//Writers the public keys allowed to append to the file besides its owner (CreateFile only)
+	Writers [][]byte
+	//ClientSignature the signature of the client over the fields of SignedOp
+	ClientSignature []byte
+}
+
+//OpID returns the id of the op with the given uuid of the client with the given key, see OpRecord.ID
+func OpID(clientKey []byte, uuid string) string {
+	return hex.EncodeToString(clientKey) + "/" + uuid
+}
+
+//ID identifies the op on the chain and in the mempools: its uuid is chosen by its client,
+//so it is scoped by the key of the client, otherwise any client could claim the uuids of the ops of others
+func (o *OpRecord) ID() string {
+	return OpID(o.ClientKey, o.UUID)
+}
+
+//SignedOp returns the fields of the op covered by the client signature
+func (o *OpRecord) SignedOp() *rfslib.SignedOp {
+	return &rfslib.SignedOp{
+		Create:      o.OpType == CreateFile,
+		Delete:      o.OpType == DeleteFile,
+		NewFilename: o.NewFilename,
+		Filename:    o.Filename,
+		Record:      o.Record,
+		UUID:        o.UUID,
+		Timestamp:   o.Timestamp.UnixNano(),
+		Writers:     o.Writers,
+	}
+}
+
+//VerifyClientSignature checks that the op was signed by the client with ClientKey
+func (o *OpRecord) VerifyClientSignature() error {
+	if !o.SignedOp().Verify(o.ClientKey, o.ClientSignature) {
+		return errors.New("invalid client signature for op " + o.UUID)
+	}
+	return nil
+}
+
+//BlockOp returns the fields of the op included in its block
+func (o *OpRecord) BlockOp() *rfslib.BlockOp {
+	return &rfslib.BlockOp{
+		SignedOp:        *o.SignedOp(),
+		MinerID:         o.MinerID,
+		ClientKey:       o.ClientKey,
+		ClientSignature: o.ClientSignature,
+	}
+}
+
+//Bytes returns the canonical encoding of the op, a leaf of the ops merkle tree of its block
+func (o *OpRecord) Bytes() []byte {
+	return o.BlockOp().Bytes()
 }
@@ -0,0 +1,77 @@
+package blockchain_test
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+func TestOpClientSignature(t *testing.T) {
+	key := testKey("client")
+	record := rfslib.Record{}
+	record.FromString("record")
+	op := &blockchain.OpRecord{
+		OpType:    blockchain.AppendRec,
+		MinerID:   "1",
+		Filename:  "file",
+		Record:    &record,
+		UUID:      "uuid",
+		Timestamp: time.Unix(0, 42),
+		ClientKey: key.Public().(ed25519.PublicKey),
+	}
+	op.ClientSignature = op.SignedOp().Sign(key)
+	if err := op.VerifyClientSignature(); err != nil {
+		t.Error(err)
+	}
+	//the miner id is not signed by the client
+	op.MinerID = "2"
+	if err := op.VerifyClientSignature(); err != nil {
+		t.Error(err)
+	}
+	tampered := rfslib.Record{}
+	tampered.FromString("tampered")
+	op.Record = &tampered
+	if op.VerifyClientSignature() == nil {
+		t.Error("Ops with a modified record should not verify")
+	}
+	op.Record = &record
+	op.ClientKey = testKey("other").Public().(ed25519.PublicKey)
+	if op.VerifyClientSignature() == nil {
+		t.Error("Ops should not verify with the key of another client")
+	}
+}
+
+func TestNamespaceOpSignature(t *testing.T) {
+	key := testKey("client")
+	op := &blockchain.OpRecord{
+		OpType:      blockchain.RenameFile,
+		Filename:    "file",
+		NewFilename: "renamed",
+		UUID:        "uuid",
+		Timestamp:   time.Unix(0, 42),
+		ClientKey:   key.Public().(ed25519.PublicKey),
+	}
+	op.ClientSignature = op.SignedOp().Sign(key)
+	if err := op.VerifyClientSignature(); err != nil {
+		t.Error(err)
+	}
+	op.NewFilename = "other"
+	if op.VerifyClientSignature() == nil {
+		t.Error("Rename ops with a modified new filename should not verify")
+	}
+	op.OpType = blockchain.DeleteFile
+	op.NewFilename = ""
+	op.ClientSignature = op.SignedOp().Sign(key)
+	if err := op.VerifyClientSignature(); err != nil {
+		t.Error(err)
+	}
+	for _, opType := range []blockchain.OpType{blockchain.CreateFile, blockchain.AppendRec} {
+		op.OpType = opType
+		if op.VerifyClientSignature() == nil {
+			t.Errorf("A signed delete op should not verify as %s", opType)
+		}
+	}
+}
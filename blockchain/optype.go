@@ -10,6 +10,10 @@ const (
 	CreateFile OpType = OpType(tcp.CreateFile)
 	//AppendRec operation on the blockchain
 	AppendRec OpType = OpType(tcp.AppendRec)
+	//DeleteFile operation on the blockchain
+	DeleteFile OpType = OpType(tcp.DeleteFile)
+	//RenameFile operation on the blockchain
+	RenameFile OpType = OpType(tcp.RenameFile)
 )
 
 func (t OpType) String() string {
@@ -18,6 +22,10 @@ func (t OpType) String() string {
 		return "CreateFile"
 	case AppendRec:
 		return "AppendRec"
+	case DeleteFile:
+		return "DeleteFile"
+	case RenameFile:
+		return "RenameFile"
 	default:
 		return "UnknownMsg"
 	}
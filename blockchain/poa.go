@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//ProofOfAuthority round robin proof of authority: the miners of a fixed authority set take turns extending the chain.
+//The miner in turn for a height may seal its block one period after the parent block, every following miner of the set
+//one period later than the one before it, so that the chain keeps growing while miners are down.
+//Sealing only waits for the turn of the miner, no pow is needed. The keys of the authorities must be known to the tree
+//(see SetMinerKeys) so that only the authorities can sign their blocks
+type ProofOfAuthority struct {
+	authorities []string
+	period      time.Duration
+}
+
+//NewProofOfAuthority returns the proof of authority of the miners with the given ids
+func NewProofOfAuthority(authorities []string, period time.Duration) *ProofOfAuthority {
+	sorted := append([]string{}, authorities...)
+	sort.Strings(sorted)
+	return &ProofOfAuthority{
+		authorities: sorted,
+		period:      period,
+	}
+}
+
+//Difficulty is zero, blocks take no work
+func (p *ProofOfAuthority) Difficulty(parent *BlockTreeNode, isOp bool) int {
+	return 0
+}
+
+//earliest returns the earliest timestamp of a block of the miner extending parent
+func (p *ProofOfAuthority) earliest(minerID string, parent *BlockTreeNode) (int64, error) {
+	index := sort.SearchStrings(p.authorities, minerID)
+	if index == len(p.authorities) || p.authorities[index] != minerID {
+		return 0, fmt.Errorf("miner %s is not an authority", minerID)
+	}
+	n := len(p.authorities)
+	inTurn := (parent.Height + 1) % n
+	distance := (index - inTurn + n) % n
+	return parent.Block.Timestamp + int64(distance+1)*int64(p.period), nil
+}
+
+//Seal waits for the turn of the miner and sets the timestamp of the block to it if the block is earlier
+func (p *ProofOfAuthority) Seal(block *Block, parent *BlockTreeNode, stop chan struct{}) *Block {
+	earliest, err := p.earliest(block.MinerID, parent)
+	if err != nil {
+		//this miner is not allowed to seal blocks, wait until it is stopped
+		<-stop
+		return nil
+	}
+	sealed := *block
+	if sealed.Timestamp < earliest {
+		sealed.Timestamp = earliest
+	}
+	timer := time.NewTimer(time.Until(time.Unix(0, sealed.Timestamp)))
+	defer timer.Stop()
+	select {
+	case <-stop:
+		return nil
+	case <-timer.C:
+		return &sealed
+	}
+}
+
+//VerifySeal checks that the block is sealed by an authority in its turn
+func (p *ProofOfAuthority) VerifySeal(block *Block, parent *BlockTreeNode) error {
+	if parent == nil {
+		index := sort.SearchStrings(p.authorities, block.MinerID)
+		if index == len(p.authorities) || p.authorities[index] != block.MinerID {
+			return fmt.Errorf("miner %s is not an authority", block.MinerID)
+		}
+		return nil
+	}
+	earliest, err := p.earliest(block.MinerID, parent)
+	if err != nil {
+		return err
+	}
+	if block.Timestamp < earliest {
+		return fmt.Errorf("block of miner %s sealed before its turn", block.MinerID)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/KostasAronis/go-rfs/hashing"
+)
+
+//checkStopEvery the number of nonces a worker tries between checks for a stop
+const checkStopEvery = 1 << 14
+
+//ProofOfWork seals blocks by searching a nonce that gives their hash the difficulty of the tree, see difficultyAfter
+type ProofOfWork struct {
+	//hashes the number of hashes tried by Seal, first for 64 bit alignment of its atomic accesses
+	hashes  uint64
+	tree    *BlockTree
+	workers int
+}
+
+//NewProofOfWork returns the proof of work of the tree, searching nonces with the given number of goroutines
+func NewProofOfWork(tree *BlockTree, workers int) *ProofOfWork {
+	return &ProofOfWork{
+		tree:    tree,
+		workers: workers,
+	}
+}
+
+//Difficulty returns the retargeted difficulty of the tree
+func (p *ProofOfWork) Difficulty(parent *BlockTreeNode, isOp bool) int {
+	return p.tree.difficultyAfter(parent, isOp)
+}
+
+//Seal searches the nonce of the block with the worker pool
+func (p *ProofOfWork) Seal(block *Block, parent *BlockTreeNode, stop chan struct{}) *Block {
+	return calculateNonceParallel(block, p.Difficulty(parent, block.IsOp), p.workers, stop, &p.hashes)
+}
+
+//Hashes returns the number of hashes tried by Seal so far, counted every checkStopEvery hashes of a worker
+func (p *ProofOfWork) Hashes() uint64 {
+	return atomic.LoadUint64(&p.hashes)
+}
+
+//VerifySeal checks the pow of the block. Since the difficulty of a block depends on its parent,
+//blocks of unknown parents are checked against the difficulty after the tip, lowered by a retarget step
+func (p *ProofOfWork) VerifySeal(block *Block, parent *BlockTreeNode) error {
+	var difficulty int
+	if parent == nil {
+		difficulty = p.tree.difficultyAfter(p.tree.tip, block.IsOp) - maxRetargetStep
+	} else {
+		difficulty = p.tree.difficultyAfter(parent, block.IsOp)
+	}
+	valid, err := block.IsValid(difficulty)
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return errors.New("invalid block")
+	}
+	return nil
+}
+
+//calculateNonceParallel searches the nonce of the block with the given number of workers, adding the tried nonces to hashes.
+//Returns nil if stop is closed first. Every worker has returned by the time calculateNonceParallel returns
+func calculateNonceParallel(block *Block, difficulty int, workers int, stop chan struct{}, hashes *uint64) *Block {
+	found := make(chan Block, 1)
+	quit := make(chan struct{})
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			tryFindNonce(worker, workers, difficulty, *block, found, quit, hashes)
+		}(i)
+	}
+	var minedBlock *Block
+	select {
+	case <-stop:
+	case mined := <-found:
+		minedBlock = &mined
+	}
+	close(quit)
+	wg.Wait()
+	return minedBlock
+}
+
+//tryFindNonce tries every 32 bit nonce of the block. When they are exhausted the timestamp of the block
+//is rolled forward by the number of workers, so that every worker searches its own timestamps.
+//The header is encoded once per timestamp and only its nonce is replaced on every try
+func tryFindNonce(worker int, workers int, difficulty int, block Block, found chan Block, quit chan struct{}, hashes *uint64) {
+	start := block.Timestamp
+	for roll := int64(worker); ; roll += int64(workers) {
+		block.Timestamp = start + roll
+		header := block.Header()
+		for nonce := uint32(0); ; nonce++ {
+			if nonce%checkStopEvery == 0 {
+				if nonce != 0 {
+					atomic.AddUint64(hashes, checkStopEvery)
+				}
+				select {
+				case <-quit:
+					return
+				default:
+				}
+			}
+			SetHeaderNonce(header, nonce)
+			if hashing.LeadingZeroBits(block.HashAlgorithm.Sum(header)) >= difficulty {
+				block.Nonce = nonce
+				atomic.AddUint64(hashes, uint64(nonce%checkStopEvery)+1)
+				select {
+				case found <- block:
+				default:
+				}
+				return
+			}
+			if nonce == math.MaxUint32 {
+				atomic.AddUint64(hashes, checkStopEvery)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package blockchain
+
+import (
+	"fmt"
+	"time"
+)
+
+//maxRetargetStep the maximum number of bits the difficulty changes by on a single retarget
+const maxRetargetStep = 2
+
+/*
+	Retargeting: every RetargetInterval blocks the difficulty of both op and noop blocks is shifted
+	by up to maxRetargetStep bits, so that the blocks of the last interval would have taken TargetBlockTime each.
+	The shift only depends on the timestamps of the chain it is computed on, so every miner computes the same
+	difficulty for a block and blocks mined with another difficulty are rejected.
+*/
+
+//retargetStep the change in bits that brings the actual duration of a window of blocks closer to the expected one:
+//one bit for every factor of two between them
+func retargetStep(actual int64, expected int64) int {
+	step := 0
+	for step < maxRetargetStep && actual*2 <= expected {
+		actual *= 2
+		step++
+	}
+	for step > -maxRetargetStep && actual >= expected*2 {
+		expected *= 2
+		step--
+	}
+	return step
+}
+
+//shiftAfter returns the difficulty shift of a block extending parent. The shift changes on the heights that are
+//a multiple of RetargetInterval (starting from the second interval, the genesis block has no timestamp)
+//depending on the time between the first and the last block of the previous interval
+func (b *BlockTree) shiftAfter(parent *BlockTreeNode) int {
+	height := parent.Height + 1
+	interval := b.RetargetInterval
+	if interval < 2 || b.TargetBlockTime <= 0 || height%interval != 0 || height < 2*interval {
+		return parent.DifficultyShift
+	}
+	first := parent
+	for first.Height > height-interval {
+		first = first.Parent
+	}
+	actual := parent.Block.Timestamp - first.Block.Timestamp
+	expected := int64(interval-1) * int64(b.TargetBlockTime) * int64(time.Millisecond)
+	shift := parent.DifficultyShift + retargetStep(actual, expected)
+	//the easiest blocks need no pow at all
+	if min := -b.minDifficulty(); shift < min {
+		shift = min
+	}
+	return shift
+}
+
+func (b *BlockTree) minDifficulty() int {
+	if b.OpDiff < b.NoopDiff {
+		return b.OpDiff
+	}
+	return b.NoopDiff
+}
+
+//difficultyAfter returns the difficulty of an op or noop block extending parent
+func (b *BlockTree) difficultyAfter(parent *BlockTreeNode, isOp bool) int {
+	if isOp {
+		return b.OpDiff + b.shiftAfter(parent)
+	}
+	return b.NoopDiff + b.shiftAfter(parent)
+}
+
+//NextDifficulty returns the difficulty of an op or noop block extending the block with the given hash
+func (b *BlockTree) NextDifficulty(parentHash string, isOp bool) (int, error) {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	parent, ok := b.nodes[parentHash]
+	if !ok {
+		return 0, fmt.Errorf("unknown block %s", parentHash)
+	}
+	return b.consensus.Difficulty(parent, isOp), nil
+}
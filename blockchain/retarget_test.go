@@ -0,0 +1,75 @@
+package blockchain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+)
+
+//appendMinedBlock mines and appends a noop block with the given timestamp on prev
+func appendMinedBlock(t *testing.T, tree *blockchain.BlockTree, prev *blockchain.Block, timestamp time.Duration) *blockchain.Block {
+	block := blockTemplate(t, prev, "a")
+	block.Timestamp = int64(timestamp)
+	sealed := sealTestBlock(t, tree, block, make(chan struct{}))
+	err := tree.AppendBlock(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestRetarget(t *testing.T) {
+	tree := newTestTree(t)
+	tree.NoopDiff = 1
+	tree.OpDiff = 2
+	tree.RetargetInterval = 4
+	tree.TargetBlockTime = 1000
+	block := tree.GenesisNode
+	//blocks 100 times faster than the target
+	for i := 1; i < 8; i++ {
+		block = appendMinedBlock(t, tree, block, time.Duration(i)*10*time.Millisecond)
+		if d, _ := tree.NextDifficulty(mustHash(t, block), false); i < 7 && d != 1 {
+			t.Errorf("Difficulty should not change before the second interval, got %d at height %d", d, i+1)
+		}
+	}
+	tip := mustHash(t, block)
+	if d, _ := tree.NextDifficulty(tip, false); d != 1+2 {
+		t.Errorf("Difficulty should rise by the max step when blocks are too fast, got %d", d)
+	}
+	if d, _ := tree.NextDifficulty(tip, true); d != 2+2 {
+		t.Errorf("Op block difficulty should rise by the same step, got %d", d)
+	}
+	//blocks on target keep the difficulty
+	start := 100 * time.Second
+	for i := 0; i < 4; i++ {
+		block = appendMinedBlock(t, tree, block, start+time.Duration(i)*time.Second)
+	}
+	if d, _ := tree.NextDifficulty(mustHash(t, block), false); d != 3 {
+		t.Errorf("Difficulty should not change when blocks are on target, got %d", d)
+	}
+	//blocks 3 times slower than the target
+	start = 200 * time.Second
+	for i := 0; i < 4; i++ {
+		block = appendMinedBlock(t, tree, block, start+time.Duration(i)*3*time.Second)
+	}
+	if d, _ := tree.NextDifficulty(mustHash(t, block), false); d != 2 {
+		t.Errorf("Difficulty should drop by a bit when blocks are too slow, got %d", d)
+	}
+	//the difficulty is recomputed when the tree is rebuilt from its blocks
+	restored := &blockchain.BlockTree{
+		GenesisNode:      tree.GenesisNode,
+		Blocks:           tree.Blocks,
+		NoopDiff:         1,
+		OpDiff:           2,
+		RetargetInterval: 4,
+		TargetBlockTime:  1000,
+	}
+	err := restored.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, _ := restored.NextDifficulty(mustHash(t, block), false); d != 2 {
+		t.Errorf("Restored tree should compute the same difficulty, got %d", d)
+	}
+}
@@ -0,0 +1,62 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//medianTimeSpan the number of ancestors whose median timestamp a block must be later than
+const medianTimeSpan = 11
+
+//defaultMaxTimeDrift the drift allowed when the tree has no MaxTimeDrift
+const defaultMaxTimeDrift = time.Minute
+
+//medianTimePast returns the median timestamp of the last medianTimeSpan blocks of the chain ending on node
+func medianTimePast(node *BlockTreeNode) int64 {
+	timestamps := []int64{}
+	for ; node != nil && len(timestamps) < medianTimeSpan; node = node.Parent {
+		timestamps = append(timestamps, node.Block.Timestamp)
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2]
+}
+
+func (b *BlockTree) maxTimeDrift() time.Duration {
+	if b.MaxTimeDrift <= 0 {
+		return defaultMaxTimeDrift
+	}
+	return time.Duration(b.MaxTimeDrift) * time.Millisecond
+}
+
+//checkTimestamp checks that the block is later than the median of its last ancestors and not too far
+//ahead of the local clock. Blocks from the future may become valid later, e.g. when they are synced again
+func (b *BlockTree) checkTimestamp(block *Block, parent *BlockTreeNode) error {
+	if median := medianTimePast(parent); block.Timestamp <= median {
+		return fmt.Errorf("block timestamp %s is not after the median of its ancestors %s", formatTimestamp(block.Timestamp), formatTimestamp(median))
+	}
+	if limit := time.Now().Add(b.maxTimeDrift()).UnixNano(); block.Timestamp > limit {
+		return fmt.Errorf("block timestamp %s is too far in the future", formatTimestamp(block.Timestamp))
+	}
+	return nil
+}
+
+//NextTimestamp returns the timestamp of a block extending the block with the given hash: the current time,
+//unless the clock of this miner is behind the median of the ancestors
+func (b *BlockTree) NextTimestamp(parentHash string) (int64, error) {
+	b.m.RLock()
+	defer b.m.RUnlock()
+	parent, ok := b.nodes[parentHash]
+	if !ok {
+		return 0, fmt.Errorf("unknown block %s", parentHash)
+	}
+	now := time.Now().UnixNano()
+	if median := medianTimePast(parent); now <= median {
+		return median + 1, nil
+	}
+	return now, nil
+}
+
+func formatTimestamp(timestamp int64) string {
+	return time.Unix(0, timestamp).UTC().Format(time.RFC3339Nano)
+}
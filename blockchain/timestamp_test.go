@@ -0,0 +1,43 @@
+package blockchain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+)
+
+func TestBlockTimestamps(t *testing.T) {
+	tree := newTestTree(t)
+	tree.MaxTimeDrift = 1000
+	now := time.Now().UnixNano()
+	block := tree.GenesisNode
+	for i := 0; i < 5; i++ {
+		block = appendTestBlock(t, tree, block, "a")
+	}
+	newTimedBlock := func(timestamp int64) *blockchain.Block {
+		timed := blockTemplate(t, block, "a")
+		timed.Timestamp = timestamp
+		return signTestBlock(t, timed, testKey("a"))
+	}
+	//the median of the 6 blocks of the chain is the timestamp of the block at height 3
+	if tree.AppendBlock(newTimedBlock(3*int64(time.Second))) == nil {
+		t.Error("Blocks not later than the median of their ancestors should be rejected")
+	}
+	if err := tree.AppendBlock(newTimedBlock(3*int64(time.Second) + 1)); err != nil {
+		t.Error(err)
+	}
+	if tree.AppendBlock(newTimedBlock(now+int64(10*time.Second))) == nil {
+		t.Error("Blocks too far in the future should be rejected")
+	}
+	if err := tree.AppendBlock(newTimedBlock(now)); err != nil {
+		t.Error(err)
+	}
+	next, err := tree.NextTimestamp(mustHash(t, block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next < now {
+		t.Error("Next timestamp should follow the local clock")
+	}
+}
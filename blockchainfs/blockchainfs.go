@@ -7,20 +7,21 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/DistributedClocks/GoVector/govec"
 	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/blockstore"
 	"github.com/KostasAronis/go-rfs/filesystem"
+	"github.com/KostasAronis/go-rfs/hashing"
 	"github.com/KostasAronis/go-rfs/minerconfig"
+	"github.com/KostasAronis/go-rfs/rfslib"
 	"github.com/KostasAronis/go-rfs/serialization"
 )
 
-const maxNonce uint32 = 4294967295
-const goRoutineCount = 2
-
 //BlockchainFS contains most of the logic for the operations between the blockchain and the fs
 //TODO: nil checks on type properties is communicating by sharing memory. It should be the oposite. NEEDS MOAR CHAN!!!
 //TODO: less type properties, more functional methods! Do not keep channels _loose_ on the _global_ scope.
@@ -33,34 +34,43 @@ type BlockchainFS struct {
 	pauseNoopChan   chan bool
 	resumeNoopChan  chan bool
 	resetOpMineChan chan bool
+	tipChanged      chan bool
 	isMiningOp      uint32
 	blockchain      *blockchain.BlockTree
-	FS              *filesystem.FileSystem
-	bank            map[string]int
+	//blockStore the log of the accepted blocks, nil if the config has no BlockLogFile
+	blockStore *blockstore.Store
+	FS         *filesystem.FileSystem
+	//state of FS and bank at the tip of the longest chain, guarded by stateMutex
+	stateMutex sync.Mutex
+	state      *chainState
+	//stateChanged closed and replaced every time the state moves, guarded by stateMutex
+	stateChanged chan struct{}
 	//on new staging
-	timer              *time.Timer
-	stagingOps         []*blockchain.OpRecord
-	stagingFS          *filesystem.FileSystem
-	stagingBank        map[string]int
-	waitingClientBlock []chan *blockchain.Block
+	stagingMutex sync.Mutex
+	timer        *time.Timer
+	miningOps    []*blockchain.OpRecord
+	stagingOps   []*blockchain.OpRecord
+	stagingFS    *filesystem.FileSystem
+	stagingBank  coinBank
+	mempool      *mempool
+	//waiting for confirmations
+	confirmMutex   sync.Mutex
+	confirmWaiters map[string]*opWaiter
+	//blocks waiting for their ancestors
+	orphanMutex sync.Mutex
+	orphans     *orphanPool
+	//externalMutex serializes AddExternalBlock, blocks arrive both from the flood handler and from sync
+	externalMutex sync.Mutex
 	//unused?
 	currentlyMinedHash atomic.Value
 }
 
-//Init initializes BlockchainFS
+//Init initializes BlockchainFS and starts mining
 func (b *BlockchainFS) Init(config *minerconfig.Config) error {
-	b.config = config
-	b.FS = &filesystem.FileSystem{}
-	b.FS.Init()
-	err := b.initBlockchain()
+	err := b.init(config)
 	if err != nil {
 		return err
 	}
-	b.bank = map[string]int{}
-	b.pauseNoopChan = make(chan bool)
-	b.resumeNoopChan = make(chan bool)
-	b.resetOpMineChan = make(chan bool)
-	atomic.StoreUint32(&b.isMiningOp, 0)
 	go b.mineForever()
 	go func() {
 		SIGNALS := make(chan os.Signal, 1)
@@ -75,58 +85,177 @@ func (b *BlockchainFS) Init(config *minerconfig.Config) error {
 	return nil
 }
 
-//AddBlock adds mined block
+//init loads the blockchain and the state at its longest chain, without mining
+func (b *BlockchainFS) init(config *minerconfig.Config) error {
+	b.config = config
+	if config.PrivateKey == nil {
+		return errors.New("miner has no private key to sign its blocks")
+	}
+	err := b.initBlockchain()
+	if err != nil {
+		return err
+	}
+	err = b.openBlockStore()
+	if err != nil {
+		return err
+	}
+	store, err := b.newFileStore()
+	if err != nil {
+		return err
+	}
+	//the fs and bank are rebuilt by applying every block of the longest chain
+	b.state = newChainState(b.blockchain.GetNode(b.config.CommonMinerConfig.GenesisBlockHash), filesystem.New(store, b.config.CommonMinerConfig.MaxRecords()))
+	b.FS = b.state.fs
+	b.stateChanged = make(chan struct{})
+	b.followLongestChain()
+	b.confirmWaiters = map[string]*opWaiter{}
+	b.orphans = newOrphanPool()
+	b.mempool = newMempool()
+	b.pauseNoopChan = make(chan bool)
+	b.resumeNoopChan = make(chan bool)
+	b.resetOpMineChan = make(chan bool, 1)
+	b.tipChanged = make(chan bool, 1)
+	atomic.StoreUint32(&b.isMiningOp, 0)
+	return nil
+}
+
+//addBlock adds a block to the tree and to the block log
 func (b *BlockchainFS) addBlock(block *blockchain.Block) error {
 	err := b.blockchain.AppendBlock(block)
 	if err != nil {
 		return err
 	}
+	if b.blockStore != nil {
+		err = b.blockStore.Append(block)
+		if err != nil {
+			return fmt.Errorf("could not store block: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+//newFileStore returns the configured store of the files
+func (b *BlockchainFS) newFileStore() (filesystem.Store, error) {
+	switch b.config.FileStore {
+	case "", minerconfig.MemoryFileStore:
+		return filesystem.NewMemoryStore(), nil
+	case minerconfig.DiskFileStore:
+		if b.config.FileStoreDir == "" {
+			return nil, errors.New("disk file store needs a FileStoreDir")
+		}
+		return filesystem.NewDiskStore(b.config.FileStoreDir)
+	}
+	return nil, fmt.Errorf("unknown file store %s", b.config.FileStore)
+}
+
+//openBlockStore opens the configured block log and adds the blocks stored in it to the tree
+func (b *BlockchainFS) openBlockStore() error {
+	if b.config.BlockLogFile == "" {
+		return nil
+	}
+	store, err := blockstore.Open(b.config.BlockLogFile)
+	if err != nil {
+		return err
+	}
+	err = store.Replay(b.blockchain.AppendBlock)
+	if err != nil {
+		store.Close()
+		return fmt.Errorf("could not replay block log %s: %s", b.config.BlockLogFile, err.Error())
+	}
+	log.Printf("replayed %d blocks from %s", store.Len(), b.config.BlockLogFile)
+	b.blockStore = store
+	return nil
+}
+
+//TryStageOp validates the op against the staging fs and adds it to the next op block.
+//The returned channel receives the result of the op once its block has the configured
+//number of confirmations on the longest chain
+func (b *BlockchainFS) TryStageOp(op *blockchain.OpRecord) (chan *OpResult, error) {
+	b.stagingMutex.Lock()
+	defer b.stagingMutex.Unlock()
+	err := b.checkNewOp(op)
+	if err != nil {
+		return nil, err
+	}
+	waiter, err := b.addConfirmWaiter(op)
+	if err != nil {
+		return nil, err
+	}
+	err = b.addNewOp(op)
+	if err != nil {
+		b.removeConfirmWaiter(waiter)
+		return nil, err
+	}
+	return waiter.result, nil
+}
+
+//stageOp validates the op against the staging fs and adds it to the next op block
+func (b *BlockchainFS) stageOp(op *blockchain.OpRecord) error {
+	b.stagingMutex.Lock()
+	defer b.stagingMutex.Unlock()
+	err := b.checkNewOp(op)
+	if err != nil {
+		return err
+	}
+	return b.addNewOp(op)
+}
+
+//checkNewOp returns ErrDuplicateOp if the op is already in the mempool or on the longest chain, stagingMutex must be held
+func (b *BlockchainFS) checkNewOp(op *blockchain.OpRecord) error {
+	if b.mempool.has(op.ID()) || b.onChain(op.ID()) {
+		return ErrDuplicateOp
+	}
 	return nil
 }
 
-func (b *BlockchainFS) TryStageOp(op *blockchain.OpRecord) (chan *blockchain.Block, error) {
+//addNewOp stages an op checked by checkNewOp and keeps it in the mempool, stagingMutex must be held
+func (b *BlockchainFS) addNewOp(op *blockchain.OpRecord) error {
 	if b.timer == nil {
 		b.initStaging()
 	}
-	if op.OpType == blockchain.CreateFile {
-		coins, ok := b.stagingBank[op.MinerID]
-		if !ok {
-			return nil, errors.New(op.MinerID + " invalid coin count")
-		}
-		if coins-b.config.CommonMinerConfig.NumCoinsPerFileCreate < 0 {
-			return nil, errors.New(op.MinerID + " invalid coin count")
-		}
-		stagingCopy := b.stagingFS.Clone()
-		_, err := b.stagingFS.AddFile(op.Filename)
-		if err != nil {
-			b.stagingFS = stagingCopy
-			return nil, err
-		}
-		b.stagingBank[op.MinerID] = coins - b.config.CommonMinerConfig.NumCoinsPerFileCreate
+	err := b.stageOpLocked(op)
+	if err != nil {
+		return err
 	}
-	if op.OpType == blockchain.AppendRec {
-		stagingCopy := b.stagingFS.Clone()
-		_, err := b.stagingFS.AppendRecord(op.Filename, op.Record)
-		if err != nil {
-			b.stagingFS = stagingCopy
-			return nil, err
-		}
+	b.mempool.add(op)
+	return nil
+}
+
+//stageOpLocked is stageOp for callers already holding stagingMutex on an initialized staging
+func (b *BlockchainFS) stageOpLocked(op *blockchain.OpRecord) error {
+	err := op.VerifyClientSignature()
+	if err != nil {
+		return err
+	}
+	_, err = b.applyOp(b.stagingFS, b.stagingBank, minerAccount(b.config.PublicKey()), op)
+	if err != nil {
+		return err
 	}
 	if b.timer == nil {
 		b.startTimer()
 	}
 	b.stagingOps = append(b.stagingOps, op)
-	blockChan := make(chan *blockchain.Block)
-	b.waitingClientBlock = append(b.waitingClientBlock, blockChan)
-	return blockChan, nil
+	return nil
+}
+
+//newGenesisBlock returns the first block of every chain hashed with the given algorithm
+func newGenesisBlock(algorithm hashing.Algorithm) blockchain.Block {
+	return blockchain.Block{
+		Version:       blockchain.HeaderVersion,
+		HashAlgorithm: algorithm,
+		PrevHash:      "",
+		Nonce:         0,
+		MinerID:       "0",
+		IsOp:          false,
+	}
 }
+
 func (b *BlockchainFS) initBlockchain() error {
-	genesisBlock := blockchain.Block{
-		PrevHash: "",
-		Nonce:    0,
-		MinerID:  "0",
-		IsOp:     false,
+	algorithm, err := b.config.CommonMinerConfig.Algorithm()
+	if err != nil {
+		return err
 	}
+	genesisBlock := newGenesisBlock(algorithm)
 	genesisBlockHash, err := genesisBlock.ComputeHash()
 	if err != nil {
 		return err
@@ -139,10 +268,59 @@ func (b *BlockchainFS) initBlockchain() error {
 		Blocks: []*blockchain.Block{
 			&genesisBlock,
 		},
-		NoopDiff: b.config.CommonMinerConfig.PowPerNoOpBlock,
-		OpDiff:   b.config.CommonMinerConfig.PowPerOpBlock,
+		NoopDiff:         b.config.CommonMinerConfig.PowPerNoOpBlock,
+		OpDiff:           b.config.CommonMinerConfig.PowPerOpBlock,
+		RetargetInterval: b.config.CommonMinerConfig.RetargetInterval,
+		TargetBlockTime:  b.config.CommonMinerConfig.TargetBlockTime,
+		MaxTimeDrift:     b.config.CommonMinerConfig.MaxTimeDrift,
+		HashAlgorithm:    algorithm,
+	}
+	err = b.setConsensus(b.blockchain)
+	if err != nil {
+		return err
+	}
+	err = b.blockchain.Init()
+	if err != nil {
+		return err
+	}
+	return b.setMinerKeys(b.blockchain)
+}
+
+//setConsensus sets the configured consensus of the tree, before it is initialized
+func (b *BlockchainFS) setConsensus(blockTree *blockchain.BlockTree) error {
+	switch b.config.CommonMinerConfig.Consensus {
+	case "", minerconfig.ProofOfWork:
+		blockTree.SetConsensus(blockchain.NewProofOfWork(blockTree, b.config.Workers()))
+	case minerconfig.ProofOfAuthority:
+		keys, err := b.config.MinerKeys()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return errors.New("proof of authority needs the public keys of the peer miners")
+		}
+		if b.config.CommonMinerConfig.AuthorityBlockTime <= 0 {
+			return errors.New("proof of authority needs an AuthorityBlockTime")
+		}
+		authorities := []string{}
+		for id := range keys {
+			authorities = append(authorities, id)
+		}
+		period := time.Duration(b.config.CommonMinerConfig.AuthorityBlockTime) * time.Millisecond
+		blockTree.SetConsensus(blockchain.NewProofOfAuthority(authorities, period))
+	default:
+		return fmt.Errorf("unknown consensus %s", b.config.CommonMinerConfig.Consensus)
+	}
+	return nil
+}
+
+//setMinerKeys restricts the blocks accepted by the tree to the configured miners
+func (b *BlockchainFS) setMinerKeys(blockTree *blockchain.BlockTree) error {
+	keys, err := b.config.MinerKeys()
+	if err != nil {
+		return err
 	}
-	b.blockchain.Init()
+	blockTree.SetMinerKeys(keys)
 	return nil
 }
 
@@ -151,6 +329,61 @@ func (b *BlockchainFS) BlockExists(hash string) bool {
 	return block != nil
 }
 
+//GetBlock returns the block with the given hash or nil if it is unknown
+func (b *BlockchainFS) GetBlock(hash string) *blockchain.Block {
+	return b.blockchain.GetBlockByHash(hash)
+}
+
+//OpProof returns the proof that the op with the given uuid of the client with the given key is included in a block
+//of the longest chain. Returns rfslib.OpNotFoundError if it is not
+func (b *BlockchainFS) OpProof(clientKey []byte, uuid string) (*rfslib.OpProof, error) {
+	id := blockchain.OpID(clientKey, uuid)
+	b.stateMutex.Lock()
+	hash := b.state.opBlock(id)
+	b.stateMutex.Unlock()
+	block := b.blockchain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, rfslib.OpNotFoundError(uuid)
+	}
+	proof, err := block.OpProof(id)
+	if err != nil {
+		return nil, err
+	}
+	proof.Height = b.blockchain.GetNode(hash).Height
+	return proof, nil
+}
+
+//ReadRecordAt returns the record at idx of the file as it was after the block at height of the longest chain,
+//also if the file has since been deleted or renamed. It does not wait for the record, and the blocks without
+//ConfirmsPerFileAppend confirmations may still be rewound
+func (b *BlockchainFS) ReadRecordAt(fName string, idx int, height int) (*rfslib.Record, error) {
+	b.stateMutex.Lock()
+	node := b.state.node
+	b.stateMutex.Unlock()
+	if height < 0 || height > node.Height {
+		return nil, fmt.Errorf("no block at height %d", height)
+	}
+	for node.Height > height {
+		node = node.Parent
+	}
+	s, err := b.stateAt(node.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return s.fs.ReadRecord(fName, idx)
+}
+
+//Tip returns the hash and height of the last block of the longest chain
+func (b *BlockchainFS) Tip() (string, int) {
+	node := b.blockchain.GetLastNode()
+	return node.Hash, node.Height
+}
+
+//HashesAfter returns up to max hashes of the longest chain following fromHash, see blockchain.BlockTree.HashesAfter
+func (b *BlockchainFS) HashesAfter(fromHash string, max int) []string {
+	return b.blockchain.HashesAfter(fromHash, max)
+}
+
 func (b *BlockchainFS) Store(filename string) error {
 	bytes, err := serialization.EncodeToBytes(b.blockchain)
 	if err != nil {
@@ -169,6 +402,18 @@ func (b *BlockchainFS) Restore(filename string) error {
 	if err != nil {
 		return err
 	}
+	err = b.setConsensus(blockTree)
+	if err != nil {
+		return err
+	}
+	err = blockTree.Init()
+	if err != nil {
+		return err
+	}
+	err = b.setMinerKeys(blockTree)
+	if err != nil {
+		return err
+	}
 	b.blockchain = blockTree
 	return nil
 }
@@ -183,63 +428,96 @@ func (b *BlockchainFS) execTimer() {
 	<-b.timer.C
 	log.Println("started mining new op block")
 	b.pauseNoopChan <- true
+	b.stagingMutex.Lock()
 	b.timer = nil
-	b.bank = b.stagingBank
-	b.FS = b.stagingFS
-	newBlock := b.createStageBlock(b.stagingOps)
-	hash, err := newBlock.ComputeHash()
+	b.miningOps = b.stagingOps
+	b.stagingOps = []*blockchain.OpRecord{}
+	b.stagingMutex.Unlock()
+	newBlock := b.createStageBlock(b.miningOps)
+	if newBlock != nil {
+		b.addMinedOpBlock(newBlock)
+	}
+	b.resumeNoopChan <- true
+	b.stagingMutex.Lock()
+	b.miningOps = nil
+	b.stagingMutex.Unlock()
+	//the ops of the block stay in the mempool until they are on the longest chain
+	b.rebaseStaging(nil)
+}
+
+func (b *BlockchainFS) addMinedOpBlock(newBlock *blockchain.Block) {
+	hash, err := newBlock.Hash()
 	if err != nil {
 		panic(err)
 	}
-	err = b.tryAddBlock(newBlock)
+	log.Printf("mined op block %s\n", hash)
+	err = b.validateBlock(newBlock)
+	if err == nil {
+		err = b.tryAddBlock(newBlock)
+	}
 	if err != nil {
-		panic(err)
+		log.Printf("mined op block %s rejected: %s", hash, err.Error())
+		return
 	}
 	log.Println("added op block")
-	b.resumeNoopChan <- true
-	log.Printf("mined op block %s\n", hash)
-	for _, c := range b.waitingClientBlock {
-		c <- newBlock
+	if len(b.opsNotOnChain(newBlock.Ops)) > 0 {
+		log.Printf("op block %s is not on the longest chain, its ops stay in the mempool", hash)
 	}
 }
 
 func (b *BlockchainFS) createStageBlock(stagingOps []*blockchain.OpRecord) *blockchain.Block {
 	atomic.StoreUint32(&b.isMiningOp, 1)
-	prevBlock := b.blockchain.GetLastBlock()
-	prevHash, err := prevBlock.ComputeHash()
-	if err != nil {
-		panic(err)
-	}
+	prevHash := b.blockchain.GetLastNode().Hash
 	newBlock := blockchain.Block{
+		Version:       blockchain.HeaderVersion,
+		HashAlgorithm: b.blockchain.HashAlgorithm,
 		PrevHash:      prevHash,
 		MinerID:       b.config.MinerID,
 		Nonce:         0,
 		IsOp:          true,
+		Timestamp:     b.nextTimestamp(prevHash),
 		Ops:           stagingOps,
-		Confirmations: 0,
+		PublicKey:     b.config.PublicKey(),
 	}
-	mined := make(chan *blockchain.Block)
-	stopChan := make(chan bool)
+	mined := make(chan *blockchain.Block, 1)
+	stopChan := make(chan struct{})
 	go b.mine(&newBlock, mined, stopChan)
 	select {
 	case minedBlock := <-mined:
 		atomic.StoreUint32(&b.isMiningOp, 0)
 		return minedBlock
 	case <-b.resetOpMineChan:
-		stopChan <- true
-		return b.createStageBlock(stagingOps)
+		close(stopChan)
+		//ops included by the blocks of other miners are dropped from the block
+		ops := b.opsNotOnChain(stagingOps)
+		if len(ops) == 0 {
+			atomic.StoreUint32(&b.isMiningOp, 0)
+			return nil
+		}
+		return b.createStageBlock(ops)
 	}
 }
 
-func (b *BlockchainFS) startMiningNoop(out chan *blockchain.Block, stop chan bool) {
-	prevHash, err := b.blockchain.GetLastBlock().ComputeHash()
+//nextTimestamp returns the timestamp of a block mined on prevHash, see blockchain.BlockTree.NextTimestamp
+func (b *BlockchainFS) nextTimestamp(prevHash string) int64 {
+	timestamp, err := b.blockchain.NextTimestamp(prevHash)
 	if err != nil {
+		//blocks are only mined on the tip, which is always in the tree
 		panic(err)
 	}
+	return timestamp
+}
+
+func (b *BlockchainFS) startMiningNoop(out chan *blockchain.Block, stop chan struct{}) {
+	prevHash := b.blockchain.GetLastNode().Hash
 	noop := blockchain.Block{
-		PrevHash: prevHash,
-		MinerID:  b.config.MinerID,
-		IsOp:     false,
+		Version:       blockchain.HeaderVersion,
+		HashAlgorithm: b.blockchain.HashAlgorithm,
+		PrevHash:      prevHash,
+		MinerID:       b.config.MinerID,
+		IsOp:          false,
+		Timestamp:     b.nextTimestamp(prevHash),
+		PublicKey:     b.config.PublicKey(),
 	}
 	go b.mine(&noop, out, stop)
 }
@@ -249,7 +527,7 @@ func (b *BlockchainFS) mineForever() {
 	for {
 		log.Println("new mining loop")
 		noopMined := make(chan *blockchain.Block, 1)
-		stopChan := make(chan bool, 1)
+		stopChan := make(chan struct{})
 		go b.startMiningNoop(noopMined, stopChan)
 		for {
 			log.Println("for_START")
@@ -257,21 +535,28 @@ func (b *BlockchainFS) mineForever() {
 			case noopBlock := <-noopMined:
 				h, err := noopBlock.ComputeHash()
 				if err != nil {
-					panic(err)
+					log.Printf("could not hash mined noop block: %s", err.Error())
+					break
 				}
 				log.Printf("mined noop block: %s\n", h)
+				//the tree may reject the block, e.g. if the tip moved while it was mined, mining restarts on the tip
 				err = b.tryAddBlock(noopBlock)
 				if err != nil {
-					panic(err)
+					log.Printf("mined noop block %s rejected: %s", h, err.Error())
+					break
 				}
 				log.Printf("added noop block: %s\n", h)
 				break
 			case <-b.pauseNoopChan:
-				stopChan <- true
+				close(stopChan)
 				log.Println("noop mining paused")
 				<-b.resumeNoopChan
 				log.Println("noop mining resumed")
 				break
+			case <-b.tipChanged:
+				close(stopChan)
+				log.Println("noop mining restarted on new tip")
+				break
 			}
 			log.Println("for_END")
 			break
@@ -279,131 +564,115 @@ func (b *BlockchainFS) mineForever() {
 	}
 }
 
+//initStaging starts a new staging fs and bank from the current state and the ops of the op block currently mined
 func (b *BlockchainFS) initStaging() {
+	b.stateMutex.Lock()
 	b.stagingFS = b.FS.Clone()
-	b.stagingBank = map[string]int{}
-	for k, v := range b.bank {
-		b.stagingBank[k] = v
+	b.stagingBank = b.state.bank.fork()
+	for _, op := range b.miningOps {
+		if b.state.opBlock(op.ID()) != "" {
+			continue
+		}
+		_, err := b.applyOp(b.stagingFS, b.stagingBank, minerAccount(b.config.PublicKey()), op)
+		if err != nil {
+			log.Printf("op %s of mined block not applicable on staging: %s", op.UUID, err.Error())
+		}
 	}
-
-	b.waitingClientBlock = []chan *blockchain.Block{}
+	b.stateMutex.Unlock()
+	b.stagingOps = []*blockchain.OpRecord{}
 }
 
+//tryAddBlock adds a block already checked by validateBlock to the tree and moves the state to the longest chain
 func (b *BlockchainFS) tryAddBlock(block *blockchain.Block) error {
 	err := b.addBlock(block)
 	if err != nil {
 		return err
 	}
-	coins := b.config.CommonMinerConfig.MinedCoinsPerNoOpBlock
-	if block.IsOp {
-		coins = b.config.CommonMinerConfig.MinedCoinsPerOpBlock
+	dropped, changed := b.followLongestChain()
+	if changed {
+		b.rebaseStaging(dropped)
 	}
-	b.bank[block.MinerID] = b.bank[block.MinerID] + coins
+	b.checkConfirmations()
 	log.Println("flooding block")
 	b.BlockToFlood <- block
 	<-b.BlockFlooded
 	return nil
 }
 
+//AddExternalBlock validates and adds a block received from a peer, along with any orphans descending from it.
+//Blocks with unknown parents are kept in the orphan pool and an *OrphanBlockError is returned.
+//Calls are serialized, so that a block received twice at the same time is only added once and a block
+//is never kept as an orphan while its parent is being added
 func (b *BlockchainFS) AddExternalBlock(block *blockchain.Block) error {
-	//TODO:!!! this should be pause-start and not reset (WHAT IF Mining was faster than opchecking & block adding)...
-	if atomic.LoadUint32(&b.isMiningOp) == 1 {
-		log.Println("reseting op for External block")
-		b.resetOpMineChan <- true
-	} else {
-		log.Println("pausing noop for External block")
-		b.pauseNoopChan <- true
-	}
-	err := b.tryAddBlock(block)
+	b.externalMutex.Lock()
+	defer b.externalMutex.Unlock()
+	hash, err := block.Hash()
 	if err != nil {
 		return err
 	}
-	if atomic.LoadUint32(&b.isMiningOp) != 1 {
-		b.resumeNoopChan <- true
+	if b.BlockExists(hash) {
+		return nil
+	}
+	if !b.BlockExists(block.PrevHash) {
+		return b.addOrphan(block)
+	}
+	err = b.addExternalBlock(block)
+	if err != nil {
+		return err
 	}
+	b.adoptOrphans(hash)
 	return nil
 }
 
-//DONT LOOK FURTHER (FOR NOW)
-//Mine Works on solving a block nonce
-func (b *BlockchainFS) mine(block *blockchain.Block, out chan *blockchain.Block, stop chan bool) {
-	var difficulty int
-	if block.IsOp {
-		difficulty = b.config.CommonMinerConfig.PowPerOpBlock
-	} else {
-		difficulty = b.config.CommonMinerConfig.PowPerNoOpBlock
-	}
-	parallelOut := make(chan *blockchain.Block)
-	go calculateNonceParallel(block, difficulty, parallelOut, stop)
-	blockType := "NoOp"
-	if block.IsOp {
-		blockType = "Op"
+func (b *BlockchainFS) addExternalBlock(block *blockchain.Block) error {
+	err := b.validateBlock(block)
+	if err != nil {
+		return err
 	}
-	for {
-		select {
-		case <-stop:
-			b.GovecLogger.LogLocalEvent("stopping mining "+blockType+" block", govec.GoLogOptions{Priority: govec.INFO})
-		case minedBlock := <-parallelOut:
-			b.GovecLogger.LogLocalEvent("done mining "+blockType+" block", govec.GoLogOptions{Priority: govec.INFO})
-			out <- minedBlock
-		}
+	err = b.tryAddBlock(block)
+	if err != nil {
+		return err
 	}
+	b.notifyNewTip()
+	return nil
 }
 
-func calculateNonceParallel(block *blockchain.Block, difficulty int, out chan *blockchain.Block, stop chan bool) {
-	parallelOut := make(chan blockchain.Block)
-	for i := 0; i < goRoutineCount; i++ {
-		go tryFindNonce(uint32(i), difficulty, parallelOut, *block)
-	}
+//notifyNewTip restarts the mining of the current noop or op block on top of the new tip.
+//It never blocks, a restart already pending covers the new tip as well
+func (b *BlockchainFS) notifyNewTip() {
 	select {
-	case <-stop:
-		return
-	case minedBlock := <-parallelOut:
-		out <- &minedBlock
+	case b.tipChanged <- true:
+	default:
 	}
-}
-
-func tryFindNonce(goID uint32, difficulty int, out chan blockchain.Block, block blockchain.Block) {
-	for i := uint32(goID * (maxNonce / goRoutineCount)); i < (goID+1)*(maxNonce/goRoutineCount); i++ {
-		block.Nonce = uint32(i)
-		valid, err := block.HasValidNonce(difficulty)
-		if err != nil {
-			err := fmt.Errorf("Miner: tryFindNonce: error computing hash for block! %s", err.Error())
-			log.Println(err)
-			panic(err)
-		}
-		if valid {
-			out <- block
-			return
+	if atomic.LoadUint32(&b.isMiningOp) == 1 {
+		log.Println("reseting op for External block")
+		select {
+		case b.resetOpMineChan <- true:
+		default:
 		}
 	}
 }
 
-func (b *BlockchainFS) tryAddOp(op *blockchain.OpRecord) (*filesystem.FileSystem, map[string]int, error) {
-	stagingFS := b.FS.Clone()
-	stagingBank := map[string]int{}
-	for k, v := range b.bank {
-		stagingBank[k] = v
+//mine seals the block with the consensus of the tree, signs it and sends it to out.
+//Returns without sending anything once stop is closed. out must be buffered so that mine never blocks on it
+func (b *BlockchainFS) mine(block *blockchain.Block, out chan *blockchain.Block, stop chan struct{}) {
+	parent := b.blockchain.GetNode(block.PrevHash)
+	if parent == nil {
+		panic(fmt.Errorf("unknown block %s", block.PrevHash))
 	}
-	if op.OpType == blockchain.CreateFile {
-		coins, ok := stagingBank[op.MinerID]
-		if !ok {
-			return nil, nil, errors.New(op.MinerID + " invalid coin count")
-		}
-		if coins-b.config.CommonMinerConfig.NumCoinsPerFileCreate < 0 {
-			return nil, nil, errors.New(op.MinerID + " invalid coin count")
-		}
-		_, err := stagingFS.AddFile(op.Filename)
-		if err != nil {
-			return nil, nil, err
-		}
-		stagingBank[op.MinerID] = coins - b.config.CommonMinerConfig.NumCoinsPerFileCreate
+	blockType := "NoOp"
+	if block.IsOp {
+		blockType = "Op"
 	}
-	if op.OpType == blockchain.AppendRec {
-		_, err := stagingFS.AppendRecord(op.Filename, op.Record)
-		if err != nil {
-			return nil, nil, err
-		}
+	minedBlock := b.blockchain.Consensus().Seal(block, parent, stop)
+	if minedBlock == nil {
+		b.GovecLogger.LogLocalEvent("stopping mining "+blockType+" block", govec.GoLogOptions{Priority: govec.INFO})
+		return
+	}
+	b.GovecLogger.LogLocalEvent("done mining "+blockType+" block", govec.GoLogOptions{Priority: govec.INFO})
+	err := minedBlock.Sign(b.config.PrivateKey)
+	if err != nil {
+		panic(err)
 	}
-	return stagingFS, stagingBank, nil
+	out <- minedBlock
 }
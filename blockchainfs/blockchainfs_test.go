@@ -0,0 +1,155 @@
+package blockchainfs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"testing"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/minerconfig"
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+//The fixtures of the package tests. A block caches its hash the first time it is hashed, so test blocks and ops start
+//as a blockTemplate or opTemplate, get all their final fields and only then are sealed and signed
+
+//testKey a deterministic key for every miner and client id
+func testKey(id string) ed25519.PrivateKey {
+	return ed25519.NewKeyFromSeed(bytes.Repeat([]byte(id), ed25519.SeedSize)[:ed25519.SeedSize])
+}
+
+//newTestFS returns the BlockchainFS of miner "a" at the genesis block, without mining.
+//Every block and op costs or earns one coin and needs no pow
+func newTestFS(t *testing.T) *BlockchainFS {
+	genesis := newGenesisBlock(0)
+	genesisHash, err := genesis.ComputeHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &minerconfig.Config{
+		MinerID:    "a",
+		PrivateKey: testKey("a"),
+		CommonMinerConfig: minerconfig.CommonMinerConfig{
+			GenesisBlockHash:       genesisHash,
+			MinedCoinsPerOpBlock:   1,
+			MinedCoinsPerNoOpBlock: 1,
+			NumCoinsPerFileCreate:  1,
+			NumCoinsPerFileDelete:  1,
+			NumCoinsPerFileRename:  1,
+			GenOpBlockTimeout:      int(time.Hour / time.Millisecond),
+			ConfirmsPerFileCreate:  1,
+			ConfirmsPerFileAppend:  2,
+		},
+	}
+	b := &BlockchainFS{
+		BlockToFlood: make(chan *blockchain.Block),
+		BlockFlooded: make(chan bool),
+	}
+	go func() {
+		for range b.BlockToFlood {
+			b.BlockFlooded <- true
+		}
+	}()
+	err = b.init(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+//blockTemplate returns the unsealed and unsigned block of the given miner with the ops on the block with prevHash
+func blockTemplate(t *testing.T, b *BlockchainFS, prevHash string, minerID string, ops ...*blockchain.OpRecord) *blockchain.Block {
+	if b.blockchain.GetNode(prevHash) == nil {
+		t.Fatalf("unknown block %s", prevHash)
+	}
+	return &blockchain.Block{
+		Version:       blockchain.HeaderVersion,
+		HashAlgorithm: b.blockchain.HashAlgorithm,
+		PrevHash:      prevHash,
+		MinerID:       minerID,
+		IsOp:          len(ops) > 0,
+		Timestamp:     b.nextTimestamp(prevHash),
+		Ops:           ops,
+		PublicKey:     testKey(minerID).Public().(ed25519.PublicKey),
+	}
+}
+
+//sealTestBlock seals the block with the consensus of the tree and signs it with key, which also becomes its PublicKey
+func sealTestBlock(t *testing.T, b *BlockchainFS, block *blockchain.Block, key ed25519.PrivateKey) *blockchain.Block {
+	block.PublicKey = key.Public().(ed25519.PublicKey)
+	sealed := b.blockchain.Consensus().Seal(block, b.blockchain.GetNode(block.PrevHash), make(chan struct{}))
+	err := sealed.Sign(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+//newTestBlock seals and signs a block of the given miner on the block with prevHash
+func newTestBlock(t *testing.T, b *BlockchainFS, prevHash string, minerID string, ops ...*blockchain.OpRecord) *blockchain.Block {
+	return sealTestBlock(t, b, blockTemplate(t, b, prevHash, minerID, ops...), testKey(minerID))
+}
+
+//addTestBlock adds a new block of the given miner on the block with prevHash and returns its hash
+func addTestBlock(t *testing.T, b *BlockchainFS, prevHash string, minerID string, ops ...*blockchain.OpRecord) string {
+	block := newTestBlock(t, b, prevHash, minerID, ops...)
+	err := b.AddExternalBlock(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := block.Hash()
+	return hash
+}
+
+//opTemplate returns the unsigned op on filename of the given client received by miner "a".
+//AppendRec ops append a record holding their uuid
+func opTemplate(client string, opType blockchain.OpType, filename string, uuid string) *blockchain.OpRecord {
+	op := &blockchain.OpRecord{
+		MinerID:   "a",
+		OpType:    opType,
+		Filename:  filename,
+		UUID:      uuid,
+		Timestamp: time.Unix(0, 42).UTC(),
+		ClientKey: testKey(client).Public().(ed25519.PublicKey),
+	}
+	if opType == blockchain.AppendRec {
+		op.Record = testRecord(uuid)
+	}
+	return op
+}
+
+//signTestOp signs the op with the key of the given client
+func signTestOp(op *blockchain.OpRecord, client string) *blockchain.OpRecord {
+	op.ClientSignature = op.SignedOp().Sign(testKey(client))
+	return op
+}
+
+//newTestOp returns an op on filename signed by the given client, see opTemplate
+func newTestOp(client string, opType blockchain.OpType, filename string, uuid string) *blockchain.OpRecord {
+	return signTestOp(opTemplate(client, opType, filename, uuid), client)
+}
+
+//newRenameOp returns a RenameFile op signed by the given client
+func newRenameOp(client string, filename string, newName string, uuid string) *blockchain.OpRecord {
+	op := opTemplate(client, blockchain.RenameFile, filename, uuid)
+	op.NewFilename = newName
+	return signTestOp(op, client)
+}
+
+//testRecord a record holding s
+func testRecord(s string) *rfslib.Record {
+	record := &rfslib.Record{}
+	copy(record[:], s)
+	return record
+}
+
+//testAccount the bank account of the miner with the given id
+func testAccount(minerID string) string {
+	return minerAccount(testKey(minerID).Public().(ed25519.PublicKey))
+}
+
+//genesisHash the hash of the genesis block of the test fs
+func genesisHash(b *BlockchainFS) string {
+	return b.config.CommonMinerConfig.GenesisBlockHash
+}
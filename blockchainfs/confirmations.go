@@ -0,0 +1,142 @@
+package blockchainfs
+
+import (
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/filesystem"
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+//OpResult the outcome of a staged op, sent once the op is confirmed on the longest chain or dropped
+type OpResult struct {
+	//Index the index of the appended record for AppendRec ops
+	Index int
+	Err   error
+}
+
+//opWaiter tracks an op of a client until its block gets enough confirmations
+type opWaiter struct {
+	op       *blockchain.OpRecord
+	confirms int
+	result   chan *OpResult
+}
+
+//requiredConfirms the confirmations of an op, the ops changing the namespace need as many as CreateFile ops
+func (b *BlockchainFS) requiredConfirms(op *blockchain.OpRecord) int {
+	if op.OpType == blockchain.AppendRec {
+		return b.config.CommonMinerConfig.ConfirmsPerFileAppend
+	}
+	return b.config.CommonMinerConfig.ConfirmsPerFileCreate
+}
+
+//addConfirmWaiter registers a waiter for the op. Returns ErrDuplicateOp if the op already has one, which is never replaced
+func (b *BlockchainFS) addConfirmWaiter(op *blockchain.OpRecord) (*opWaiter, error) {
+	b.confirmMutex.Lock()
+	defer b.confirmMutex.Unlock()
+	if _, exists := b.confirmWaiters[op.ID()]; exists {
+		return nil, ErrDuplicateOp
+	}
+	waiter := &opWaiter{
+		op:       op,
+		confirms: b.requiredConfirms(op),
+		result:   make(chan *OpResult, 1),
+	}
+	b.confirmWaiters[op.ID()] = waiter
+	return waiter, nil
+}
+
+//removeConfirmWaiter removes the waiter if it is still registered for its op
+func (b *BlockchainFS) removeConfirmWaiter(waiter *opWaiter) {
+	b.confirmMutex.Lock()
+	defer b.confirmMutex.Unlock()
+	if b.confirmWaiters[waiter.op.ID()] == waiter {
+		delete(b.confirmWaiters, waiter.op.ID())
+	}
+}
+
+//failConfirmWaiter answers the waiter of an op that can no longer be added to the chain
+func (b *BlockchainFS) failConfirmWaiter(op *blockchain.OpRecord, err error) {
+	b.confirmMutex.Lock()
+	defer b.confirmMutex.Unlock()
+	waiter, ok := b.confirmWaiters[op.ID()]
+	if !ok {
+		return
+	}
+	waiter.result <- &OpResult{Index: -1, Err: err}
+	delete(b.confirmWaiters, op.ID())
+}
+
+//checkConfirmations answers the waiters whose ops have enough confirmations on the chain the state follows
+func (b *BlockchainFS) checkConfirmations() {
+	b.confirmMutex.Lock()
+	defer b.confirmMutex.Unlock()
+	if len(b.confirmWaiters) == 0 {
+		return
+	}
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	for id, waiter := range b.confirmWaiters {
+		hash := b.state.opBlock(id)
+		if hash == "" {
+			continue
+		}
+		confirmations := b.state.node.Height - b.blockchain.GetNode(hash).Height
+		if confirmations < waiter.confirms {
+			continue
+		}
+		idx := -1
+		if waiter.op.OpType == blockchain.AppendRec {
+			idx = b.state.recordIndex(id)
+		}
+		waiter.result <- &OpResult{Index: idx}
+		delete(b.confirmWaiters, id)
+	}
+}
+
+//confirmedRecords returns the number of records of the file whose blocks have ConfirmsPerFileAppend confirmations
+//on the chain the state follows. A file created or renamed to fName in the unconfirmed blocks has no confirmed records.
+//stateMutex must be held
+func (b *BlockchainFS) confirmedRecords(fName string) (int, error) {
+	count, err := b.state.fs.TotalRecords(fName)
+	if err != nil {
+		return 0, err
+	}
+	node := b.state.node
+	for i := 0; i < b.config.CommonMinerConfig.ConfirmsPerFileAppend && node != nil; i++ {
+		for _, op := range b.state.blockOps(node.Hash) {
+			switch {
+			case op.OpType == blockchain.AppendRec && op.Filename == fName:
+				count--
+			case op.OpType == blockchain.CreateFile && op.Filename == fName,
+				op.OpType == blockchain.RenameFile && op.NewFilename == fName:
+				return 0, nil
+			}
+		}
+		node = node.Parent
+	}
+	return count, nil
+}
+
+//WaitRecord returns the record at idx of the file once its block has ConfirmsPerFileAppend confirmations on the longest chain,
+//blocking until then or until cancel is closed. Records removed by a reorganization while waiting are never returned.
+//Returns FileDoesNotExistError if the file is not on the longest chain
+func (b *BlockchainFS) WaitRecord(fName string, idx int, cancel <-chan struct{}) (*rfslib.Record, error) {
+	for {
+		b.stateMutex.Lock()
+		count, err := b.confirmedRecords(fName)
+		if err == nil && idx < count {
+			record, err := b.state.fs.ReadRecord(fName, idx)
+			b.stateMutex.Unlock()
+			return record, err
+		}
+		changed := b.stateChanged
+		b.stateMutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-changed:
+		case <-cancel:
+			return nil, filesystem.ErrWaitCancelled
+		}
+	}
+}
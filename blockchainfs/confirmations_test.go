@@ -0,0 +1,236 @@
+package blockchainfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/filesystem"
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+func TestConfirmations(t *testing.T) {
+	create := newTestOp("client", blockchain.CreateFile, "f1", "create")
+	append0 := newTestOp("client", blockchain.AppendRec, "f1", "append 0")
+	append1 := newTestOp("client", blockchain.AppendRec, "f1", "append 1")
+	//expected the result of the waiter of an op: nil if it must not be answered yet
+	type expected struct {
+		index int
+		err   bool
+	}
+	//every case stages its ops on the miner a and then adds blocks on genesis <- a1, a has one coin
+	tests := []struct {
+		name    string
+		staged  []*blockchain.OpRecord
+		build   func(b *BlockchainFS, a1 string)
+		results map[*blockchain.OpRecord]*expected
+	}{
+		{
+			name:   "not enough confirmations",
+			staged: []*blockchain.OpRecord{create},
+			build: func(b *BlockchainFS, a1 string) {
+				addTestBlock(t, b, a1, "a", create)
+			},
+			results: map[*blockchain.OpRecord]*expected{create: nil},
+		},
+		{
+			name:   "create confirmed",
+			staged: []*blockchain.OpRecord{create},
+			build: func(b *BlockchainFS, a1 string) {
+				a2 := addTestBlock(t, b, a1, "a", create)
+				addTestBlock(t, b, a2, "b")
+			},
+			results: map[*blockchain.OpRecord]*expected{create: {index: -1}},
+		},
+		{
+			name:   "appends need more confirmations",
+			staged: []*blockchain.OpRecord{create, append0, append1},
+			build: func(b *BlockchainFS, a1 string) {
+				a2 := addTestBlock(t, b, a1, "a", create, append0)
+				addTestBlock(t, b, a2, "a", append1)
+			},
+			results: map[*blockchain.OpRecord]*expected{create: {index: -1}, append0: nil, append1: nil},
+		},
+		{
+			name:   "appends confirmed with their index",
+			staged: []*blockchain.OpRecord{create, append0, append1},
+			build: func(b *BlockchainFS, a1 string) {
+				a2 := addTestBlock(t, b, a1, "a", create, append0)
+				a3 := addTestBlock(t, b, a2, "a", append1)
+				a4 := addTestBlock(t, b, a3, "a")
+				addTestBlock(t, b, a4, "a")
+			},
+			results: map[*blockchain.OpRecord]*expected{create: {index: -1}, append0: {index: 0}, append1: {index: 1}},
+		},
+		{
+			name:   "dropped by a reorganization before it is confirmed",
+			staged: []*blockchain.OpRecord{create},
+			build: func(b *BlockchainFS, a1 string) {
+				addTestBlock(t, b, a1, "a", create)
+				b2 := addTestBlock(t, b, a1, "b")
+				addTestBlock(t, b, b2, "b")
+				if !b.mempool.has(create.ID()) {
+					t.Error("The dropped op should be staged again")
+				}
+			},
+			results: map[*blockchain.OpRecord]*expected{create: nil},
+		},
+		{
+			name:   "confirmed on the new branch",
+			staged: []*blockchain.OpRecord{create, append0},
+			build: func(b *BlockchainFS, a1 string) {
+				addTestBlock(t, b, a1, "a", create, append0)
+				b2 := addTestBlock(t, b, a1, "b")
+				b3 := addTestBlock(t, b, b2, "b", create)
+				b4 := addTestBlock(t, b, b3, "b", append0)
+				b5 := addTestBlock(t, b, b4, "b")
+				addTestBlock(t, b, b5, "b")
+			},
+			results: map[*blockchain.OpRecord]*expected{create: {index: -1}, append0: {index: 0}},
+		},
+		{
+			name:   "invalid on the new branch",
+			staged: []*blockchain.OpRecord{create, append0},
+			build: func(b *BlockchainFS, a1 string) {
+				addTestBlock(t, b, a1, "a", create, append0)
+				b2 := addTestBlock(t, b, a1, "b")
+				addTestBlock(t, b, b2, "b", newTestOp("other", blockchain.CreateFile, "f1", "create"))
+			},
+			results: map[*blockchain.OpRecord]*expected{create: {index: -1, err: true}, append0: {index: -1, err: true}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestFS(t)
+			a1 := addTestBlock(t, b, genesisHash(b), "a")
+			waiters := map[*blockchain.OpRecord]chan *OpResult{}
+			for _, op := range test.staged {
+				results, err := b.TryStageOp(op)
+				if err != nil {
+					t.Fatal(err)
+				}
+				waiters[op] = results
+			}
+			test.build(b, a1)
+			for op, expected := range test.results {
+				result := waitResult(waiters[op])
+				switch {
+				case expected == nil && result != nil:
+					t.Errorf("%s should not be answered yet, got %v", op.UUID, result)
+				case expected == nil:
+				case result == nil:
+					t.Errorf("%s should be answered", op.UUID)
+				case expected.err != (result.Err != nil):
+					t.Errorf("%s: expected error %v, got %v", op.UUID, expected.err, result.Err)
+				case result.Index != expected.index:
+					t.Errorf("%s: expected index %d, got %d", op.UUID, expected.index, result.Index)
+				}
+			}
+		})
+	}
+}
+
+func TestWaitRecord(t *testing.T) {
+	create := newTestOp("client", blockchain.CreateFile, "f1", "create")
+	append0 := newTestOp("client", blockchain.AppendRec, "f1", "append 0")
+	fork := newTestOp("client", blockchain.AppendRec, "f1", "fork")
+	//every case waits on record 0 of f1 after genesis <- a1 <- a2 and then builds on them,
+	//pending fails the test if the waiter has been answered. The waiter is cancelled after the build
+	tests := []struct {
+		name   string
+		a2Ops  []*blockchain.OpRecord
+		build  func(b *BlockchainFS, a1 string, a2 string, pending func())
+		record string
+		err    error
+	}{
+		{
+			name:  "confirmed",
+			a2Ops: []*blockchain.OpRecord{create, append0},
+			build: func(b *BlockchainFS, a1 string, a2 string, pending func()) {
+				a3 := addTestBlock(t, b, a2, "a")
+				pending()
+				addTestBlock(t, b, a3, "a")
+			},
+			record: "append 0",
+		},
+		{
+			name:  "not confirmed",
+			a2Ops: []*blockchain.OpRecord{create, append0},
+			build: func(b *BlockchainFS, a1 string, a2 string, pending func()) {
+				addTestBlock(t, b, a2, "a")
+			},
+			err: filesystem.ErrWaitCancelled,
+		},
+		{
+			name:  "removed by a reorganization",
+			a2Ops: []*blockchain.OpRecord{create, append0},
+			build: func(b *BlockchainFS, a1 string, a2 string, pending func()) {
+				addTestBlock(t, b, a2, "a")
+				pending()
+				b2 := addTestBlock(t, b, a1, "b")
+				b3 := addTestBlock(t, b, b2, "b")
+				addTestBlock(t, b, b3, "b")
+			},
+			err: rfslib.FileDoesNotExistError(""),
+		},
+		{
+			name:  "replaced by a reorganization",
+			a2Ops: []*blockchain.OpRecord{create},
+			build: func(b *BlockchainFS, a1 string, a2 string, pending func()) {
+				addTestBlock(t, b, a2, "a", append0)
+				pending()
+				b3 := addTestBlock(t, b, a2, "b")
+				b4 := addTestBlock(t, b, b3, "b", fork)
+				b5 := addTestBlock(t, b, b4, "b")
+				pending()
+				addTestBlock(t, b, b5, "b")
+			},
+			record: "fork",
+		},
+	}
+	type result struct {
+		record *rfslib.Record
+		err    error
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestFS(t)
+			a1 := addTestBlock(t, b, genesisHash(b), "a")
+			a2 := addTestBlock(t, b, a1, "a", test.a2Ops...)
+			cancel := make(chan struct{})
+			done := make(chan result, 1)
+			go func() {
+				record, err := b.WaitRecord("f1", 0, cancel)
+				done <- result{record, err}
+			}()
+			pending := func() {
+				select {
+				case r := <-done:
+					t.Fatalf("WaitRecord should not be answered yet, got %v %v", r.record, r.err)
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+			test.build(b, a1, a2, pending)
+			var r result
+			select {
+			case r = <-done:
+			case <-time.After(100 * time.Millisecond):
+				close(cancel)
+				r = <-done
+			}
+			switch {
+			case test.err == nil && r.err != nil:
+				t.Errorf("unexpected error %v", r.err)
+			case test.err == nil && r.record.ToString() != testRecord(test.record).ToString():
+				t.Errorf("expected record %q, got %q", test.record, r.record.ToString())
+			case test.err == filesystem.ErrWaitCancelled && r.err != test.err:
+				t.Errorf("expected %v, got %v %v", test.err, r.record, r.err)
+			}
+			if _, ok := test.err.(rfslib.FileDoesNotExistError); ok {
+				if _, ok := r.err.(rfslib.FileDoesNotExistError); !ok {
+					t.Errorf("expected FileDoesNotExistError, got %v %v", r.record, r.err)
+				}
+			}
+		})
+	}
+}
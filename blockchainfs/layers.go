@@ -0,0 +1,87 @@
+package blockchainfs
+
+//maxLayers the number of layers a layeredMap may stack before it is flattened into a single one
+const maxLayers = 16
+
+//removed marks a key deleted in a layer while it is still set in the layers below
+type removed struct{}
+
+//layeredMap a copy-on-write map. fork shares the entries of the map with the fork instead of copying them:
+//both keep their own writes in a new top layer and read through to the frozen layers below.
+//Frozen layers are never written, so a fork may be read without the lock guarding the map it was forked from
+type layeredMap struct {
+	parent  *layeredMap
+	entries map[string]interface{}
+	depth   int
+}
+
+func newLayeredMap() *layeredMap {
+	return &layeredMap{entries: map[string]interface{}{}}
+}
+
+func (m *layeredMap) get(key string) (interface{}, bool) {
+	for l := m; l != nil; l = l.parent {
+		v, ok := l.entries[key]
+		if !ok {
+			continue
+		}
+		if _, isRemoved := v.(removed); isRemoved {
+			return nil, false
+		}
+		return v, true
+	}
+	return nil, false
+}
+
+func (m *layeredMap) set(key string, v interface{}) {
+	m.entries[key] = v
+}
+
+func (m *layeredMap) remove(key string) {
+	if m.parent == nil {
+		delete(m.entries, key)
+		return
+	}
+	m.entries[key] = removed{}
+}
+
+//fork freezes the entries of the map and returns a new map sharing them
+func (m *layeredMap) fork() *layeredMap {
+	frozen := m.parent
+	if len(m.entries) > 0 || frozen == nil {
+		frozen = &layeredMap{parent: m.parent, entries: m.entries, depth: m.depth}
+		m.parent = frozen
+		m.entries = map[string]interface{}{}
+		m.depth = frozen.depth + 1
+		if m.depth > maxLayers {
+			m.flatten()
+		}
+	}
+	return &layeredMap{parent: frozen, entries: map[string]interface{}{}, depth: frozen.depth + 1}
+}
+
+//flatten copies the entries of every layer into a single one, the frozen layers are left to the forks still using them
+func (m *layeredMap) flatten() {
+	m.entries = m.all()
+	m.parent = nil
+	m.depth = 0
+}
+
+//all returns the entries of the map merged from every layer
+func (m *layeredMap) all() map[string]interface{} {
+	layers := []*layeredMap{}
+	for l := m; l != nil; l = l.parent {
+		layers = append(layers, l)
+	}
+	entries := map[string]interface{}{}
+	for i := len(layers) - 1; i >= 0; i-- {
+		for k, v := range layers[i].entries {
+			if _, isRemoved := v.(removed); isRemoved {
+				delete(entries, k)
+				continue
+			}
+			entries[k] = v
+		}
+	}
+	return entries
+}
@@ -0,0 +1,46 @@
+package blockchainfs
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestLayeredMapFork(t *testing.T) {
+	m := newLayeredMap()
+	m.set("a", 1)
+	m.set("b", 2)
+	fork := m.fork()
+	m.set("a", 3)
+	m.remove("b")
+	fork.set("c", 4)
+	if all := m.all(); !reflect.DeepEqual(all, map[string]interface{}{"a": 3}) {
+		t.Errorf("Writes of the fork should not change the map, got %v", all)
+	}
+	if all := fork.all(); !reflect.DeepEqual(all, map[string]interface{}{"a": 1, "b": 2, "c": 4}) {
+		t.Errorf("Writes of the map should not change the fork, got %v", all)
+	}
+	if _, ok := m.get("b"); ok {
+		t.Error("A removed key should not be read through to the layers below")
+	}
+}
+
+func TestLayeredMapFlatten(t *testing.T) {
+	m := newLayeredMap()
+	forks := []*layeredMap{}
+	for i := 0; i < 3*maxLayers; i++ {
+		m.set(strconv.Itoa(i), i)
+		forks = append(forks, m.fork())
+	}
+	if m.depth > maxLayers {
+		t.Errorf("Expected at most %d layers, got %d", maxLayers, m.depth)
+	}
+	for i, fork := range forks {
+		if all := fork.all(); len(all) != i+1 {
+			t.Errorf("Expected fork %d to keep %d entries, got %d", i, i+1, len(all))
+		}
+	}
+	if v, ok := m.get("0"); !ok || v != 0 {
+		t.Errorf("Expected the first entry after flattening, got %v", v)
+	}
+}
@@ -0,0 +1,79 @@
+package blockchainfs
+
+import (
+	"errors"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+)
+
+//ErrDuplicateOp returned when an op is already in the mempool or on the longest chain
+var ErrDuplicateOp = errors.New("op already known")
+
+//mempool the ops received from clients and peers that are not on the longest chain yet, in arrival order.
+//Ops are indexed by id, see blockchain.OpRecord.ID
+type mempool struct {
+	ops   map[string]*blockchain.OpRecord
+	order []string
+}
+
+func newMempool() *mempool {
+	return &mempool{
+		ops:   map[string]*blockchain.OpRecord{},
+		order: []string{},
+	}
+}
+
+func (p *mempool) has(id string) bool {
+	_, ok := p.ops[id]
+	return ok
+}
+
+//add keeps the op after all others, unless an op with the same id is already kept
+func (p *mempool) add(op *blockchain.OpRecord) {
+	if p.has(op.ID()) {
+		return
+	}
+	p.ops[op.ID()] = op
+	p.order = append(p.order, op.ID())
+}
+
+//prepend keeps the ops that are not already kept before all others, in the given order
+func (p *mempool) prepend(ops []*blockchain.OpRecord) {
+	front := []string{}
+	for _, op := range ops {
+		if p.has(op.ID()) {
+			continue
+		}
+		p.ops[op.ID()] = op
+		front = append(front, op.ID())
+	}
+	p.order = append(front, p.order...)
+}
+
+func (p *mempool) remove(id string) {
+	if !p.has(id) {
+		return
+	}
+	delete(p.ops, id)
+	for i, o := range p.order {
+		if o == id {
+			p.order = append(p.order[:i], p.order[i+1:]...)
+			return
+		}
+	}
+}
+
+//list returns the kept ops in arrival order
+func (p *mempool) list() []*blockchain.OpRecord {
+	ops := make([]*blockchain.OpRecord, len(p.order))
+	for i, id := range p.order {
+		ops[i] = p.ops[id]
+	}
+	return ops
+}
+
+//AddExternalOp adds an op flooded by a peer to the mempool and stages it for the next op block.
+//It returns ErrDuplicateOp if the op is already known, in which case it must not be flooded again
+func (b *BlockchainFS) AddExternalOp(op *blockchain.OpRecord) error {
+	return b.stageOp(op)
+}
@@ -0,0 +1,175 @@
+package blockchainfs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+//waitResult returns the result sent to the channel of a waiter or nil if there is none yet
+func waitResult(results chan *OpResult) *OpResult {
+	select {
+	case result := <-results:
+		return result
+	case <-time.After(100 * time.Millisecond):
+		return nil
+	}
+}
+
+func TestOpIDs(t *testing.T) {
+	b := newTestFS(t)
+	a1 := addTestBlock(t, b, genesisHash(b), "a")
+	a2 := addTestBlock(t, b, a1, "a")
+	op := newTestOp("client", blockchain.CreateFile, "f1", "u1")
+	results, err := b.TryStageOp(op)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the uuids of ops are public, reusing one must neither censor nor steal the op of another client
+	hijack := newTestOp("attacker", blockchain.CreateFile, "f2", "u1")
+	hijackResults, err := b.TryStageOp(hijack)
+	if err != nil {
+		t.Fatalf("An op with the uuid of an op of another client should be staged: %s", err)
+	}
+	if _, err := b.TryStageOp(op); err != ErrDuplicateOp {
+		t.Errorf("Staging an op twice should return ErrDuplicateOp, got %v", err)
+	}
+	a3 := addTestBlock(t, b, a2, "a", op, hijack)
+	addTestBlock(t, b, a3, "a")
+	for name, results := range map[string]chan *OpResult{"op": results, "hijack": hijackResults} {
+		result := waitResult(results)
+		if result == nil || result.Err != nil {
+			t.Errorf("The waiter of %s should be answered once it is confirmed, got %v", name, result)
+		}
+	}
+	if _, err := b.OpProof(op.ClientKey, "u1"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMempool(t *testing.T) {
+	op1 := newTestOp("client", blockchain.AppendRec, "f1", "1")
+	op2 := newTestOp("client", blockchain.AppendRec, "f1", "2")
+	op3 := newTestOp("client", blockchain.AppendRec, "f1", "3")
+	other1 := newTestOp("other", blockchain.AppendRec, "f1", "1")
+	tests := []struct {
+		name     string
+		apply    func(p *mempool)
+		expected []*blockchain.OpRecord
+	}{
+		{
+			name: "arrival order",
+			apply: func(p *mempool) {
+				p.add(op2)
+				p.add(op1)
+			},
+			expected: []*blockchain.OpRecord{op2, op1},
+		},
+		{
+			name: "duplicates are kept once",
+			apply: func(p *mempool) {
+				p.add(op1)
+				p.add(op2)
+				p.add(op1)
+				p.prepend([]*blockchain.OpRecord{op2})
+			},
+			expected: []*blockchain.OpRecord{op1, op2},
+		},
+		{
+			name: "same uuid of another client",
+			apply: func(p *mempool) {
+				p.add(op1)
+				p.add(other1)
+			},
+			expected: []*blockchain.OpRecord{op1, other1},
+		},
+		{
+			name: "prepended ops go first in their order",
+			apply: func(p *mempool) {
+				p.add(op3)
+				p.prepend([]*blockchain.OpRecord{op1, op2})
+			},
+			expected: []*blockchain.OpRecord{op1, op2, op3},
+		},
+		{
+			name: "remove",
+			apply: func(p *mempool) {
+				p.add(op1)
+				p.add(op2)
+				p.add(op3)
+				p.remove(op2.ID())
+				p.remove(other1.ID())
+			},
+			expected: []*blockchain.OpRecord{op1, op3},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := newMempool()
+			test.apply(p)
+			ops := p.list()
+			if len(ops) != len(test.expected) {
+				t.Fatalf("Expected %d ops, got %d", len(test.expected), len(ops))
+			}
+			for i := range ops {
+				if ops[i] != test.expected[i] {
+					t.Errorf("Expected op %s of %x at %d, got %s", test.expected[i].UUID, test.expected[i].ClientKey[:4], i, ops[i].UUID)
+				}
+			}
+		})
+	}
+}
+
+func TestExternalOps(t *testing.T) {
+	b := newTestFS(t)
+	a1 := addTestBlock(t, b, genesisHash(b), "a")
+	create := newTestOp("client", blockchain.CreateFile, "f1", "create")
+	if err := b.AddExternalOp(create); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.AddExternalOp(create); err != ErrDuplicateOp {
+		t.Errorf("Flooding an op twice should return ErrDuplicateOp, got %v", err)
+	}
+	if _, err := b.TryStageOp(create); err != ErrDuplicateOp {
+		t.Errorf("Staging a flooded op should return ErrDuplicateOp, got %v", err)
+	}
+	//the op is included by another miner
+	b2 := addTestBlock(t, b, a1, "b")
+	addTestBlock(t, b, b2, "b", create)
+	if b.mempool.has(create.ID()) {
+		t.Error("Ops on the longest chain should leave the mempool")
+	}
+	if err := b.AddExternalOp(create); err != ErrDuplicateOp {
+		t.Errorf("Flooding an op on the chain should return ErrDuplicateOp, got %v", err)
+	}
+	if err := b.AddExternalOp(newTestOp("client", blockchain.AppendRec, "missing", "append")); err == nil {
+		t.Error("Invalid ops should not be staged")
+	}
+	if len(b.mempool.list()) != 0 {
+		t.Error("Invalid ops should not be kept")
+	}
+}
+
+func TestStageFullFile(t *testing.T) {
+	b := newTestFS(t)
+	b.config.CommonMinerConfig.MaxRecordsPerFile = 1
+	err := b.init(b.config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a1 := addTestBlock(t, b, genesisHash(b), "a")
+	addTestBlock(t, b, a1, "a", newTestOp("client", blockchain.CreateFile, "f1", "create"))
+	if _, err := b.TryStageOp(newTestOp("client", blockchain.AppendRec, "f1", "1")); err != nil {
+		t.Fatal(err)
+	}
+	//the limit is enforced by the miner before staging, the client only learns it from the error code
+	full := newTestOp("client", blockchain.AppendRec, "f1", "2")
+	if _, err := b.TryStageOp(full); err != rfslib.FileMaxLenReachedError("f1") {
+		t.Errorf("Appending to a full file should return FileMaxLenReachedError, got %v", err)
+	}
+	if b.mempool.has(full.ID()) {
+		t.Error("Ops rejected by the limit should not be kept")
+	}
+}
@@ -0,0 +1,131 @@
+package blockchainfs
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+)
+
+//maxOrphans the maximum number of blocks kept while waiting for their ancestors
+const maxOrphans = 256
+
+//OrphanBlockError returned by AddExternalBlock for a block whose ancestry is not known yet.
+//The block is kept in the orphan pool and added as soon as its Missing ancestor is added
+type OrphanBlockError struct {
+	Hash    string
+	Missing string
+}
+
+func (e *OrphanBlockError) Error() string {
+	return fmt.Sprintf("orphan block %s: missing ancestor %s", e.Hash, e.Missing)
+}
+
+//orphanPool blocks whose parent is unknown, indexed by hash and by parent hash
+type orphanPool struct {
+	blocks   map[string]*blockchain.Block
+	byParent map[string][]string
+}
+
+func newOrphanPool() *orphanPool {
+	return &orphanPool{
+		blocks:   map[string]*blockchain.Block{},
+		byParent: map[string][]string{},
+	}
+}
+
+//add keeps the block, evicting a random orphan if the pool is full
+func (p *orphanPool) add(block *blockchain.Block, hash string) {
+	if _, exists := p.blocks[hash]; exists {
+		return
+	}
+	if len(p.blocks) >= maxOrphans {
+		for evicted := range p.blocks {
+			p.remove(evicted)
+			break
+		}
+	}
+	p.blocks[hash] = block
+	p.byParent[block.PrevHash] = append(p.byParent[block.PrevHash], hash)
+}
+
+func (p *orphanPool) remove(hash string) {
+	block, ok := p.blocks[hash]
+	if !ok {
+		return
+	}
+	delete(p.blocks, hash)
+	siblings := p.byParent[block.PrevHash]
+	for i, h := range siblings {
+		if h == hash {
+			siblings = append(siblings[:i], siblings[i+1:]...)
+			break
+		}
+	}
+	if len(siblings) == 0 {
+		delete(p.byParent, block.PrevHash)
+	} else {
+		p.byParent[block.PrevHash] = siblings
+	}
+}
+
+//missingAncestor follows the parents of the block through the pool and returns the first one not in it
+func (p *orphanPool) missingAncestor(block *blockchain.Block) string {
+	missing := block.PrevHash
+	for {
+		parent, ok := p.blocks[missing]
+		if !ok {
+			return missing
+		}
+		missing = parent.PrevHash
+	}
+}
+
+//takeChildren removes and returns the orphans whose parent is the block with the given hash
+func (p *orphanPool) takeChildren(hash string) []*blockchain.Block {
+	children := []*blockchain.Block{}
+	for _, h := range append([]string{}, p.byParent[hash]...) {
+		children = append(children, p.blocks[h])
+		p.remove(h)
+	}
+	return children
+}
+
+//addOrphan checks the pow of a block with unknown parent and keeps it in the orphan pool
+func (b *BlockchainFS) addOrphan(block *blockchain.Block) error {
+	err := b.blockchain.CheckPOW(block)
+	if err != nil {
+		return err
+	}
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+	b.orphanMutex.Lock()
+	defer b.orphanMutex.Unlock()
+	b.orphans.add(block, hash)
+	return &OrphanBlockError{
+		Hash:    hash,
+		Missing: b.orphans.missingAncestor(block),
+	}
+}
+
+//adoptOrphans adds the orphans that descend from the block with the given hash
+func (b *BlockchainFS) adoptOrphans(hash string) {
+	queue := []string{hash}
+	for len(queue) > 0 {
+		b.orphanMutex.Lock()
+		children := b.orphans.takeChildren(queue[0])
+		b.orphanMutex.Unlock()
+		queue = queue[1:]
+		for _, child := range children {
+			err := b.addExternalBlock(child)
+			childHash, _ := child.Hash()
+			if err != nil {
+				log.Printf("dropping orphan %s: %s", childHash, err.Error())
+				continue
+			}
+			queue = append(queue, childHash)
+		}
+	}
+}
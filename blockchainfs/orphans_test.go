@@ -0,0 +1,147 @@
+package blockchainfs
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/hashing"
+)
+
+func TestOrphans(t *testing.T) {
+	//blocks are built on a source miner and received by another one in the order of the case
+	src := newTestFS(t)
+	h0 := addTestBlock(t, src, genesisHash(src), "a")
+	h1 := addTestBlock(t, src, h0, "a")
+	h2 := addTestBlock(t, src, h1, "a")
+	block := func(hash string) *blockchain.Block {
+		return src.blockchain.GetBlockByHash(hash)
+	}
+	invalid := newTestBlock(t, src, h1, "b", newTestOp("client", blockchain.AppendRec, "missing", "append"))
+	shaTemplate := blockTemplate(t, src, h2, "b")
+	shaTemplate.HashAlgorithm = hashing.SHA256
+	sha := sealTestBlock(t, src, shaTemplate, testKey("b"))
+	tests := []struct {
+		name    string
+		blocks  []*blockchain.Block
+		missing []string
+		tip     string
+		orphans int
+	}{
+		{
+			name:    "in order",
+			blocks:  []*blockchain.Block{block(h0), block(h1), block(h2)},
+			missing: []string{"", "", ""},
+			tip:     h2,
+		},
+		{
+			name:    "reverse order",
+			blocks:  []*blockchain.Block{block(h2), block(h1), block(h0)},
+			missing: []string{h1, h0, ""},
+			tip:     h2,
+		},
+		{
+			name:    "missing block",
+			blocks:  []*blockchain.Block{block(h0), block(h2)},
+			missing: []string{"", h1},
+			tip:     h0,
+			orphans: 1,
+		},
+		{
+			name:    "invalid orphan is dropped",
+			blocks:  []*blockchain.Block{invalid, block(h1), block(h0)},
+			missing: []string{h1, h0, ""},
+			tip:     h1,
+		},
+		{
+			name:    "orphans are checked before they are kept",
+			blocks:  []*blockchain.Block{sha},
+			missing: []string{""},
+			tip:     genesisHash(src),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestFS(t)
+			for i, block := range test.blocks {
+				err := b.AddExternalBlock(block)
+				orphan, isOrphan := err.(*OrphanBlockError)
+				switch {
+				case test.missing[i] == "" && isOrphan:
+					t.Errorf("Block %d should not be an orphan", i)
+				case test.missing[i] != "" && !isOrphan:
+					t.Errorf("Block %d should be an orphan, got %v", i, err)
+				case isOrphan && orphan.Missing != test.missing[i]:
+					t.Errorf("Block %d should miss %s, got %s", i, test.missing[i], orphan.Missing)
+				}
+			}
+			if tip, _ := b.Tip(); tip != test.tip {
+				t.Errorf("Expected tip %s, got %s", test.tip, tip)
+			}
+			if len(b.orphans.blocks) != test.orphans {
+				t.Errorf("Expected %d orphans, got %d", test.orphans, len(b.orphans.blocks))
+			}
+		})
+	}
+}
+
+func TestOrphanPoolLimit(t *testing.T) {
+	src := newTestFS(t)
+	hash := genesisHash(src)
+	blocks := []*blockchain.Block{}
+	for i := 0; i < maxOrphans+2; i++ {
+		hash = addTestBlock(t, src, hash, "a")
+		blocks = append(blocks, src.blockchain.GetBlockByHash(hash))
+	}
+	b := newTestFS(t)
+	for _, block := range blocks[1:] {
+		b.AddExternalBlock(block)
+	}
+	if len(b.orphans.blocks) != maxOrphans {
+		t.Errorf("The orphan pool should keep at most %d blocks, got %d", maxOrphans, len(b.orphans.blocks))
+	}
+	for parent, children := range b.orphans.byParent {
+		for _, child := range children {
+			if _, ok := b.orphans.blocks[child]; !ok {
+				t.Errorf("Evicted orphan %s is still indexed under %s", child, parent)
+			}
+		}
+	}
+}
+
+func TestConcurrentExternalBlocks(t *testing.T) {
+	src := newTestFS(t)
+	h0 := addTestBlock(t, src, genesisHash(src), "a")
+	h1 := addTestBlock(t, src, h0, "a")
+	//the flood handler receives h1 before its parent while the sync with a peer receives the chain in order
+	paths := map[string][]string{
+		"flood": {h1, h0},
+		"sync":  {h0, h1},
+	}
+	for i := 0; i < 50; i++ {
+		b := newTestFS(t)
+		wg := sync.WaitGroup{}
+		for name, hashes := range paths {
+			wg.Add(1)
+			go func(name string, hashes []string) {
+				defer wg.Done()
+				for _, hash := range hashes {
+					err := b.AddExternalBlock(src.GetBlock(hash))
+					if _, isOrphan := err.(*OrphanBlockError); err != nil && !isOrphan {
+						t.Errorf("%s: %s", name, err)
+					}
+				}
+			}(name, hashes)
+		}
+		wg.Wait()
+		if tip, _ := b.Tip(); tip != h1 {
+			t.Fatalf("Expected tip %s, got %s", h1, tip)
+		}
+		if len(b.orphans.blocks) != 0 {
+			t.Fatalf("A block whose parent was added should not stay an orphan, got %d orphans", len(b.orphans.blocks))
+		}
+		if b.state.node.Hash != h1 {
+			t.Fatal("The state should follow the tip")
+		}
+	}
+}
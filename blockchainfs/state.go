@@ -0,0 +1,349 @@
+package blockchainfs
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/filesystem"
+)
+
+//minerAccount returns the bank account of the miner with the given public key, the key in hex.
+//Accounts are not indexed by MinerID since it is only bound to a key when the miner keys are configured
+func minerAccount(key ed25519.PublicKey) string {
+	return hex.EncodeToString(key)
+}
+
+//coinBank the coins of every miner account, see minerAccount. Forks of a bank share the coins of the accounts they did not change
+type coinBank struct {
+	accounts *layeredMap
+}
+
+func newCoinBank() coinBank {
+	return coinBank{accounts: newLayeredMap()}
+}
+
+func (c coinBank) coins(account string) int {
+	coins, _ := c.accounts.get(account)
+	n, _ := coins.(int)
+	return n
+}
+
+func (c coinBank) setCoins(account string, coins int) {
+	c.accounts.set(account, coins)
+}
+
+func (c coinBank) fork() coinBank {
+	return coinBank{accounts: c.accounts.fork()}
+}
+
+//chainState the fs and bank that result from applying every block of the chain ending on node.
+//Clones share the indexes of the state as layeredMaps instead of copying them
+type chainState struct {
+	node *blockchain.BlockTreeNode
+	fs   *filesystem.FileSystem
+	bank coinBank
+	//appliedOps the ops applied per block hash, used to rewind blocks
+	appliedOps *layeredMap
+	//ops the hash of the block of every op applied on the chain by op id, see blockchain.OpRecord.ID
+	ops *layeredMap
+	//records the index of the record appended by every AppendRec op applied on the chain by op id
+	records *layeredMap
+}
+
+//newChainState returns the state at the genesis block, fs must have no files
+func newChainState(genesis *blockchain.BlockTreeNode, fs *filesystem.FileSystem) *chainState {
+	return &chainState{
+		node:       genesis,
+		fs:         fs,
+		bank:       newCoinBank(),
+		appliedOps: newLayeredMap(),
+		ops:        newLayeredMap(),
+		records:    newLayeredMap(),
+	}
+}
+
+func (s *chainState) clone() *chainState {
+	return &chainState{
+		node:       s.node,
+		fs:         s.fs.Clone(),
+		bank:       s.bank.fork(),
+		appliedOps: s.appliedOps.fork(),
+		ops:        s.ops.fork(),
+		records:    s.records.fork(),
+	}
+}
+
+//opBlock returns the hash of the block of the op with the given id, empty if the op is not on the chain
+func (s *chainState) opBlock(id string) string {
+	hash, _ := s.ops.get(id)
+	h, _ := hash.(string)
+	return h
+}
+
+//recordIndex returns the index of the record appended by the AppendRec op with the given id
+func (s *chainState) recordIndex(id string) int {
+	idx, ok := s.records.get(id)
+	if !ok {
+		return -1
+	}
+	return idx.(int)
+}
+
+//blockOps returns the ops applied by the block with the given hash
+func (s *chainState) blockOps(hash string) []*blockchain.OpRecord {
+	ops, _ := s.appliedOps.get(hash)
+	applied, _ := ops.([]*blockchain.OpRecord)
+	return applied
+}
+
+//followLongestChain moves the state to the tip of the longest chain. Returns the ops of the rewound blocks
+//that are not part of the new branch and whether the state changed at all
+func (b *BlockchainFS) followLongestChain() ([]*blockchain.OpRecord, bool) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	tip := b.blockchain.GetLastNode()
+	if tip == b.state.node {
+		return nil, false
+	}
+	if ancestor := blockchain.CommonAncestor(b.state.node, tip); ancestor != b.state.node {
+		log.Printf("reorganizing chain: rewinding %d blocks to %s", b.state.node.Height-ancestor.Height, ancestor.Hash)
+	}
+	undoneOps, err := b.moveState(b.state, tip)
+	if err != nil {
+		//every block in the tree has been validated against its parent state
+		panic(fmt.Errorf("could not move state to %s: %s", tip.Hash, err.Error()))
+	}
+	close(b.stateChanged)
+	b.stateChanged = make(chan struct{})
+	dropped := []*blockchain.OpRecord{}
+	for _, op := range undoneOps {
+		if b.state.opBlock(op.ID()) == "" {
+			dropped = append(dropped, op)
+		}
+	}
+	return dropped, true
+}
+
+//moveState moves the state to target. If target is on another fork the blocks of the current branch are rewound
+//down to the common ancestor before the blocks of the new branch are applied. Returns the rewound ops
+func (b *BlockchainFS) moveState(s *chainState, target *blockchain.BlockTreeNode) ([]*blockchain.OpRecord, error) {
+	ancestor := blockchain.CommonAncestor(s.node, target)
+	undoneOps := []*blockchain.OpRecord{}
+	for s.node != ancestor {
+		undoneOps = append(b.rewindBlock(s), undoneOps...)
+	}
+	branch := make([]*blockchain.BlockTreeNode, target.Height-ancestor.Height)
+	for node := target; node != ancestor; node = node.Parent {
+		branch[node.Height-ancestor.Height-1] = node
+	}
+	for _, node := range branch {
+		err := b.applyBlock(s, node.Block, node.Hash)
+		if err != nil {
+			return undoneOps, err
+		}
+		s.node = node
+	}
+	return undoneOps, nil
+}
+
+//applyBlock applies the ops of a block extending the state and rewards its miner.
+//Either every op of the block is applied or none is
+func (b *BlockchainFS) applyBlock(s *chainState, block *blockchain.Block, hash string) error {
+	account := minerAccount(block.PublicKey)
+	applied := []*blockchain.OpRecord{}
+	for _, op := range block.Ops {
+		if s.opBlock(op.ID()) != "" {
+			err := fmt.Errorf("op %s already on chain", op.UUID)
+			b.revertOps(s, account, applied)
+			return err
+		}
+		idx, err := b.applyOp(s.fs, s.bank, account, op)
+		if err != nil {
+			b.revertOps(s, account, applied)
+			return err
+		}
+		applied = append(applied, op)
+		s.ops.set(op.ID(), hash)
+		if op.OpType == blockchain.AppendRec {
+			s.records.set(op.ID(), idx)
+		}
+	}
+	s.appliedOps.set(hash, applied)
+	s.bank.setCoins(account, s.bank.coins(account)+b.blockReward(block))
+	return nil
+}
+
+//rewindBlock reverts applyBlock for the last block of the state. Returns the ops that were reverted
+func (b *BlockchainFS) rewindBlock(s *chainState) []*blockchain.OpRecord {
+	node := s.node
+	account := minerAccount(node.Block.PublicKey)
+	s.bank.setCoins(account, s.bank.coins(account)-b.blockReward(node.Block))
+	applied := s.blockOps(node.Hash)
+	b.revertOps(s, account, applied)
+	s.appliedOps.remove(node.Hash)
+	s.node = node.Parent
+	return applied
+}
+
+//revertOps reverts the given ops paid by account, which must be the last ones applied on the state, in reverse order
+func (b *BlockchainFS) revertOps(s *chainState, account string, ops []*blockchain.OpRecord) {
+	for i := len(ops) - 1; i >= 0; i-- {
+		err := b.revertOp(s.fs, s.bank, account, ops[i])
+		if err != nil {
+			panic(fmt.Errorf("could not revert applied op %s: %s", ops[i].UUID, err.Error()))
+		}
+		s.ops.remove(ops[i].ID())
+		s.records.remove(ops[i].ID())
+	}
+}
+
+func (b *BlockchainFS) blockReward(block *blockchain.Block) int {
+	if block.IsOp {
+		return b.config.CommonMinerConfig.MinedCoinsPerOpBlock
+	}
+	return b.config.CommonMinerConfig.MinedCoinsPerNoOpBlock
+}
+
+//opCost returns the number of coins the miner of the block including an op is charged for it
+func (b *BlockchainFS) opCost(op *blockchain.OpRecord) int {
+	switch op.OpType {
+	case blockchain.CreateFile:
+		return b.config.CommonMinerConfig.NumCoinsPerFileCreate
+	case blockchain.DeleteFile:
+		return b.config.CommonMinerConfig.NumCoinsPerFileDelete
+	case blockchain.RenameFile:
+		return b.config.CommonMinerConfig.NumCoinsPerFileRename
+	}
+	return 0
+}
+
+//applyOp applies a single op on the given fs and bank, charging its cost to account, the miner of the block including it.
+//The MinerID of the op is not signed by anyone, so it is never charged. Returns the index of the appended record for AppendRec ops
+func (b *BlockchainFS) applyOp(fs *filesystem.FileSystem, bank coinBank, account string, op *blockchain.OpRecord) (int, error) {
+	//only RenameFile ops carry a new filename, since it decides the kind of op the client signed
+	if (op.OpType == blockchain.RenameFile) != (op.NewFilename != "") {
+		return -1, fmt.Errorf("op %s has an invalid new filename", op.UUID)
+	}
+	coins := bank.coins(account)
+	cost := b.opCost(op)
+	if coins-cost < 0 {
+		return -1, errors.New(account + " invalid coin count")
+	}
+	switch op.OpType {
+	case blockchain.CreateFile:
+		_, err := fs.AddOwnedFile(op.Filename, op.ClientKey, op.Writers)
+		if err != nil {
+			return -1, err
+		}
+	case blockchain.AppendRec:
+		if op.Record == nil {
+			return -1, fmt.Errorf("op %s has no record", op.UUID)
+		}
+		err := fs.CheckWriter(op.Filename, op.ClientKey)
+		if err != nil {
+			return -1, err
+		}
+		return fs.AppendRecord(op.Filename, op.Record)
+	case blockchain.DeleteFile:
+		err := fs.CheckOwner(op.Filename, op.ClientKey)
+		if err != nil {
+			return -1, err
+		}
+		err = fs.DeleteFile(op.Filename)
+		if err != nil {
+			return -1, err
+		}
+	case blockchain.RenameFile:
+		err := fs.CheckOwner(op.Filename, op.ClientKey)
+		if err != nil {
+			return -1, err
+		}
+		err = fs.RenameFile(op.Filename, op.NewFilename)
+		if err != nil {
+			return -1, err
+		}
+	default:
+		return -1, fmt.Errorf("unknown op type %s", op.OpType)
+	}
+	bank.setCoins(account, coins-cost)
+	return -1, nil
+}
+
+//revertOp undoes applyOp for the last op applied on the given fs and bank
+func (b *BlockchainFS) revertOp(fs *filesystem.FileSystem, bank coinBank, account string, op *blockchain.OpRecord) error {
+	var err error
+	switch op.OpType {
+	case blockchain.CreateFile:
+		err = fs.RemoveFile(op.Filename)
+	case blockchain.AppendRec:
+		err = fs.RemoveLastRecord(op.Filename)
+	case blockchain.DeleteFile:
+		err = fs.RestoreFile(op.Filename)
+	case blockchain.RenameFile:
+		err = fs.RenameFile(op.NewFilename, op.Filename)
+	default:
+		err = fmt.Errorf("unknown op type %s", op.OpType)
+	}
+	if err != nil {
+		return err
+	}
+	bank.setCoins(account, bank.coins(account)+b.opCost(op))
+	return nil
+}
+
+//opsNotOnChain filters out the ops that are part of the current state
+func (b *BlockchainFS) opsNotOnChain(ops []*blockchain.OpRecord) []*blockchain.OpRecord {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	notOnChain := []*blockchain.OpRecord{}
+	for _, op := range ops {
+		if b.state.opBlock(op.ID()) == "" {
+			notOnChain = append(notOnChain, op)
+		}
+	}
+	return notOnChain
+}
+
+//rebaseStaging rebuilds the staging fs and bank on top of the current state and stages again the ops of the mempool
+//that are neither on the longest chain nor in the op block being mined. The dropped ops of a reorganization go
+//before all other ops of the mempool. Ops that are no longer valid leave the mempool and fail their confirmation waiters
+func (b *BlockchainFS) rebaseStaging(dropped []*blockchain.OpRecord) {
+	b.stagingMutex.Lock()
+	b.mempool.prepend(dropped)
+	mining := map[string]bool{}
+	for _, op := range b.miningOps {
+		mining[op.ID()] = true
+	}
+	b.initStaging()
+	failed := map[*blockchain.OpRecord]error{}
+	for _, op := range b.mempool.list() {
+		if b.onChain(op.ID()) {
+			b.mempool.remove(op.ID())
+			continue
+		}
+		if mining[op.ID()] {
+			continue
+		}
+		err := b.stageOpLocked(op)
+		if err != nil {
+			log.Printf("dropping op %s: %s", op.UUID, err.Error())
+			b.mempool.remove(op.ID())
+			failed[op] = err
+		}
+	}
+	b.stagingMutex.Unlock()
+	for op, err := range failed {
+		b.failConfirmWaiter(op, err)
+	}
+}
+
+//onChain reports whether the op with the given id is on the longest chain
+func (b *BlockchainFS) onChain(id string) bool {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	return b.state.opBlock(id) != ""
+}
@@ -0,0 +1,209 @@
+package blockchainfs
+
+import (
+	"crypto/ed25519"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+)
+
+//testFiles returns the record count of every file of the state
+func testFiles(t *testing.T, b *BlockchainFS) map[string]int {
+	files := map[string]int{}
+	for _, name := range b.state.fs.ListFiles() {
+		count, err := b.state.fs.TotalRecords(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[name] = count
+	}
+	return files
+}
+
+//testBank returns the coins of the given miners
+func testBank(b *BlockchainFS, miners ...string) map[string]int {
+	bank := map[string]int{}
+	for _, miner := range miners {
+		bank[miner] = b.state.bank.coins(testAccount(miner))
+	}
+	return bank
+}
+
+func mempoolUUIDs(b *BlockchainFS) []string {
+	uuids := []string{}
+	for _, op := range b.mempool.list() {
+		uuids = append(uuids, op.UUID)
+	}
+	sort.Strings(uuids)
+	return uuids
+}
+
+func TestReorganization(t *testing.T) {
+	create := newTestOp("client", blockchain.CreateFile, "f1", "create")
+	appendRec := newTestOp("client", blockchain.AppendRec, "f1", "append")
+	//every case starts from genesis <- a1 <- a2 (create f1 and append a record)
+	tests := []struct {
+		name    string
+		build   func(b *BlockchainFS, a1 string, a2 string)
+		files   map[string]int
+		bank    map[string]int
+		mempool []string
+	}{
+		{
+			name: "no fork",
+			build: func(b *BlockchainFS, a1 string, a2 string) {
+				addTestBlock(t, b, a2, "b")
+			},
+			files:   map[string]int{"f1": 1},
+			bank:    map[string]int{"a": 1, "b": 1},
+			mempool: []string{},
+		},
+		{
+			name: "shorter fork is ignored",
+			build: func(b *BlockchainFS, a1 string, a2 string) {
+				addTestBlock(t, b, genesisHash(b), "b")
+			},
+			files:   map[string]int{"f1": 1},
+			bank:    map[string]int{"a": 1, "b": 0},
+			mempool: []string{},
+		},
+		{
+			name: "longer fork without the ops",
+			build: func(b *BlockchainFS, a1 string, a2 string) {
+				b2 := addTestBlock(t, b, a1, "b")
+				addTestBlock(t, b, b2, "b")
+			},
+			files:   map[string]int{},
+			bank:    map[string]int{"a": 1, "b": 2},
+			mempool: []string{"append", "create"},
+		},
+		{
+			name: "longer fork with the same ops",
+			build: func(b *BlockchainFS, a1 string, a2 string) {
+				b1 := addTestBlock(t, b, genesisHash(b), "b")
+				b2 := addTestBlock(t, b, b1, "b", create, appendRec)
+				addTestBlock(t, b, b2, "b")
+			},
+			files:   map[string]int{"f1": 1},
+			bank:    map[string]int{"a": 0, "b": 2},
+			mempool: []string{},
+		},
+		{
+			name: "back to the first branch",
+			build: func(b *BlockchainFS, a1 string, a2 string) {
+				b2 := addTestBlock(t, b, a1, "b")
+				addTestBlock(t, b, b2, "b")
+				a3 := addTestBlock(t, b, a2, "a")
+				addTestBlock(t, b, a3, "a")
+			},
+			files:   map[string]int{"f1": 1},
+			bank:    map[string]int{"a": 3, "b": 0},
+			mempool: []string{},
+		},
+		{
+			name: "rename and delete are rewound",
+			build: func(b *BlockchainFS, a1 string, a2 string) {
+				a3 := addTestBlock(t, b, a2, "a", newRenameOp("client", "f1", "f2", "rename"))
+				a4 := addTestBlock(t, b, a3, "a", newTestOp("client", blockchain.DeleteFile, "f2", "delete"))
+				if files := testFiles(t, b); len(files) != 0 {
+					t.Fatalf("f1 should be renamed and deleted, got %v", files)
+				}
+				b3 := addTestBlock(t, b, a2, "b")
+				b4 := addTestBlock(t, b, b3, "b")
+				addTestBlock(t, b, b4, "b")
+				if b.blockchain.GetNode(a4) == b.state.node {
+					t.Fatal("The longer fork should be followed")
+				}
+			},
+			files: map[string]int{"f1": 1},
+			bank:  map[string]int{"a": 1, "b": 3},
+			//a can only pay for one of the dropped ops when they are staged again
+			mempool: []string{"rename"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestFS(t)
+			a1 := addTestBlock(t, b, genesisHash(b), "a")
+			a2 := addTestBlock(t, b, a1, "a", create, appendRec)
+			test.build(b, a1, a2)
+			if b.state.node != b.blockchain.GetLastNode() {
+				t.Error("The state should follow the tip")
+			}
+			if files := testFiles(t, b); !reflect.DeepEqual(files, test.files) {
+				t.Errorf("Expected files %v, got %v", test.files, files)
+			}
+			if bank := testBank(b, "a", "b"); !reflect.DeepEqual(bank, test.bank) {
+				t.Errorf("Expected bank %v, got %v", test.bank, bank)
+			}
+			if uuids := mempoolUUIDs(b); !reflect.DeepEqual(uuids, test.mempool) {
+				t.Errorf("Expected mempool %v, got %v", test.mempool, uuids)
+			}
+			//the state must be the one of a miner that only ever saw the longest chain
+			fresh := newTestFS(t)
+			for _, block := range b.blockchain.GetLongestChain()[1:] {
+				err := fresh.AddExternalBlock(block)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(testFiles(t, fresh), testFiles(t, b)) || !reflect.DeepEqual(testBank(fresh, "a", "b"), testBank(b, "a", "b")) {
+				t.Error("The reorganized state differs from the state of the longest chain")
+			}
+		})
+	}
+}
+
+func TestReadRecordAt(t *testing.T) {
+	b := newTestFS(t)
+	a1 := addTestBlock(t, b, genesisHash(b), "a")
+	a2 := addTestBlock(t, b, a1, "a",
+		newTestOp("client", blockchain.CreateFile, "f1", "create"),
+		newTestOp("client", blockchain.AppendRec, "f1", "append 0"))
+	a3 := addTestBlock(t, b, a2, "a", newTestOp("client", blockchain.AppendRec, "f1", "append 1"))
+	a4 := addTestBlock(t, b, a3, "a", newTestOp("client", blockchain.DeleteFile, "f1", "delete"))
+	a5 := addTestBlock(t, b, a4, "a",
+		newTestOp("client", blockchain.CreateFile, "f1", "recreate"),
+		newTestOp("client", blockchain.AppendRec, "f1", "new"))
+	addTestBlock(t, b, a5, "a", newRenameOp("client", "f1", "f2", "rename"))
+	tests := []struct {
+		file   string
+		idx    int
+		height int
+		//record the expected record, empty if the read must fail
+		record string
+	}{
+		{"f1", 0, 1, ""},
+		{"f1", 0, 2, "append 0"},
+		{"f1", 1, 2, ""},
+		{"f1", 1, 3, "append 1"},
+		{"f1", 0, 4, ""},
+		{"f1", 0, 5, "new"},
+		{"f1", 1, 5, ""},
+		{"f1", 0, 6, ""},
+		{"f2", 0, 6, "new"},
+		{"f2", 0, 7, ""},
+		{"f2", 0, -1, ""},
+	}
+	for _, test := range tests {
+		record, err := b.ReadRecordAt(test.file, test.idx, test.height)
+		switch {
+		case test.record == "" && err == nil:
+			t.Errorf("%s[%d] at %d: expected an error, got %q", test.file, test.idx, test.height, record.ToString())
+		case test.record == "":
+		case err != nil:
+			t.Errorf("%s[%d] at %d: %s", test.file, test.idx, test.height, err.Error())
+		case *record != *testRecord(test.record):
+			t.Errorf("%s[%d] at %d: expected %q, got %q", test.file, test.idx, test.height, test.record, record.ToString())
+		}
+	}
+	if files := testFiles(t, b); len(files) != 1 || files["f2"] != 1 {
+		t.Errorf("reading at old heights should not change the state, got %v", files)
+	}
+	proof, err := b.OpProof(testKey("client").Public().(ed25519.PublicKey), "append 1")
+	if err != nil || proof.Height != 3 {
+		t.Errorf("the proof of an op should hold the height of its block, got %v %v", proof, err)
+	}
+}
@@ -0,0 +1,65 @@
+package blockchainfs
+
+import (
+	"fmt"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+)
+
+//validateBlock checks a block before it is added to the tree: its pow and parent, and that its ops can be
+//executed on top of the state of its parent (no existing files created, no appends to missing files,
+//no overspending, no appends by clients other than the owner and writers of the file and no ops already on the chain).
+//Every op must be signed by its client. Blocks may include the ops of any miner,
+//the coins of the ops are paid by the miner of the block that includes them
+func (b *BlockchainFS) validateBlock(block *blockchain.Block) error {
+	err := b.blockchain.CheckBlock(block)
+	if err != nil {
+		return err
+	}
+	if !block.IsOp && len(block.Ops) > 0 {
+		return fmt.Errorf("noop block from %s contains ops", block.MinerID)
+	}
+	ids := map[string]bool{}
+	for _, op := range block.Ops {
+		if ids[op.ID()] {
+			return fmt.Errorf("op %s included twice in block", op.UUID)
+		}
+		ids[op.ID()] = true
+		err = op.VerifyClientSignature()
+		if err != nil {
+			return err
+		}
+	}
+	if len(block.Ops) == 0 {
+		return nil
+	}
+	parentState, err := b.stateAt(block.PrevHash)
+	if err != nil {
+		return err
+	}
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+	err = b.applyBlock(parentState, block, hash)
+	if err != nil {
+		return fmt.Errorf("invalid block %s: %s", hash, err.Error())
+	}
+	return nil
+}
+
+//stateAt builds a copy of the state at the block with the given hash
+func (b *BlockchainFS) stateAt(hash string) (*chainState, error) {
+	node := b.blockchain.GetNode(hash)
+	if node == nil {
+		return nil, fmt.Errorf("unknown block %s", hash)
+	}
+	b.stateMutex.Lock()
+	s := b.state.clone()
+	b.stateMutex.Unlock()
+	_, err := b.moveState(s, node)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
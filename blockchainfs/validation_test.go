@@ -0,0 +1,144 @@
+package blockchainfs
+
+import (
+	"testing"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+)
+
+func TestValidateBlockCharges(t *testing.T) {
+	b := newTestFS(t)
+	a1 := addTestBlock(t, b, genesisHash(b), "a")
+	//b has no coins, so it can not pay for a file creation received by a
+	stolen := newTestBlock(t, b, a1, "b", newTestOp("client", blockchain.CreateFile, "f1", "u1"))
+	if b.AddExternalBlock(stolen) == nil {
+		t.Error("A block spending the coins of another miner should be rejected")
+	}
+	if bank := testBank(b, "a", "b"); bank["a"] != 1 || bank["b"] != 0 {
+		t.Errorf("Rejected block changed the bank: %v", bank)
+	}
+	addTestBlock(t, b, a1, "a", newTestOp("client", blockchain.CreateFile, "f1", "u1"))
+	if coins := testBank(b, "a")["a"]; coins != 1 {
+		t.Errorf("The miner of the block should pay for its ops, got %d coins instead of 1", coins)
+	}
+}
+
+func TestMinerAccounts(t *testing.T) {
+	b := newTestFS(t)
+	a1 := addTestBlock(t, b, genesisHash(b), "a")
+	//without configured miner keys anyone can claim the MinerID of a, but not its account
+	impostor := sealTestBlock(t, b, blockTemplate(t, b, a1, "a"), testKey("b"))
+	err := b.AddExternalBlock(impostor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bank := testBank(b, "a", "b"); bank["a"] != 1 || bank["b"] != 1 {
+		t.Errorf("The reward should go to the key that signed the block: %v", bank)
+	}
+	hash, _ := impostor.Hash()
+	spender := sealTestBlock(t, b, blockTemplate(t, b, hash, "a", newTestOp("client", blockchain.CreateFile, "f1", "u1"), newTestOp("client", blockchain.CreateFile, "f2", "u2")), testKey("b"))
+	if b.AddExternalBlock(spender) == nil {
+		t.Error("Claiming the MinerID of another miner should not give access to its coins")
+	}
+}
+
+func TestValidateBlock(t *testing.T) {
+	create := newTestOp("client", blockchain.CreateFile, "f1", "create")
+	//the record is changed after signing, so that the client signature no longer matches
+	tampered := newTestOp("client", blockchain.AppendRec, "f1", "tampered")
+	tampered.Record = testRecord("modified")
+	misnamed := opTemplate("client", blockchain.AppendRec, "f1", "misnamed")
+	misnamed.NewFilename = "f2"
+	signTestOp(misnamed, "client")
+	//every block extends genesis <- a1 <- a2 (create f1), a has one coin
+	tests := []struct {
+		name string
+		ops  []*blockchain.OpRecord
+		//mutate changes the template of the block before it is sealed and signed
+		mutate func(block *blockchain.Block)
+		valid  bool
+	}{
+		{
+			name:  "valid",
+			ops:   []*blockchain.OpRecord{newTestOp("client", blockchain.AppendRec, "f1", "append"), newTestOp("client", blockchain.CreateFile, "f2", "create f2")},
+			valid: true,
+		},
+		{
+			name: "existing file created",
+			ops:  []*blockchain.OpRecord{newTestOp("client", blockchain.CreateFile, "f1", "create again")},
+		},
+		{
+			name: "append to missing file",
+			ops:  []*blockchain.OpRecord{newTestOp("client", blockchain.AppendRec, "missing", "append")},
+		},
+		{
+			name: "overspending",
+			ops:  []*blockchain.OpRecord{newTestOp("client", blockchain.CreateFile, "f2", "create f2"), newTestOp("client", blockchain.CreateFile, "f3", "create f3")},
+		},
+		{
+			name: "append by another client",
+			ops:  []*blockchain.OpRecord{newTestOp("other", blockchain.AppendRec, "f1", "append")},
+		},
+		{
+			name: "delete by another client",
+			ops:  []*blockchain.OpRecord{newTestOp("other", blockchain.DeleteFile, "f1", "delete")},
+		},
+		{
+			name: "op already on chain",
+			ops:  []*blockchain.OpRecord{create},
+		},
+		{
+			name: "op twice in block",
+			ops:  []*blockchain.OpRecord{newTestOp("client", blockchain.AppendRec, "f1", "append"), newTestOp("client", blockchain.AppendRec, "f1", "append")},
+		},
+		{
+			name: "invalid client signature",
+			ops:  []*blockchain.OpRecord{tampered},
+		},
+		{
+			name: "new filename on another op than RenameFile",
+			ops:  []*blockchain.OpRecord{misnamed},
+		},
+		{
+			name: "noop block with ops",
+			ops:  []*blockchain.OpRecord{newTestOp("client", blockchain.AppendRec, "f1", "append")},
+			mutate: func(block *blockchain.Block) {
+				block.IsOp = false
+			},
+		},
+		{
+			name: "invalid later op reverts the earlier ones",
+			ops:  []*blockchain.OpRecord{newTestOp("client", blockchain.AppendRec, "f1", "append"), newTestOp("client", blockchain.AppendRec, "missing", "append missing")},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := newTestFS(t)
+			a1 := addTestBlock(t, b, genesisHash(b), "a")
+			a2 := addTestBlock(t, b, a1, "a", create)
+			template := blockTemplate(t, b, a2, "a", test.ops...)
+			if test.mutate != nil {
+				test.mutate(template)
+			}
+			err := b.AddExternalBlock(sealTestBlock(t, b, template, testKey("a")))
+			if test.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("The block should be rejected")
+			}
+			if b.state.node.Hash != a2 {
+				t.Error("A rejected block should not move the state")
+			}
+			if files := testFiles(t, b); len(files) != 1 || files["f1"] != 0 {
+				t.Errorf("A rejected block should not change the files, got %v", files)
+			}
+			if bank := testBank(b, "a"); bank["a"] != 1 {
+				t.Errorf("A rejected block should not change the bank, got %v", bank)
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+//Package blockstore persists the accepted blocks of a miner in an append only log, replayed when the miner restarts
+package blockstore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/serialization"
+)
+
+//recordHeaderSize every record of the log is the big endian length and crc32 of the gob encoded block, followed by the block
+const recordHeaderSize = 8
+
+//maxRecordSize bounds the length of a record so that a corrupted length is detected before allocating it
+const maxRecordSize = 1 << 30
+
+//Store an append only log of blocks with an index of the offset of every block record by block hash.
+//Every block is synced to disk before Append returns. A record that was not completely written when the miner
+//stopped, the torn final write of a crash, is detected by its length and checksum and truncated when the log is opened
+type Store struct {
+	m     sync.Mutex
+	file  *os.File
+	size  int64
+	index map[string]int64
+}
+
+//Open opens the log at path, creating it if it does not exist, and indexes its blocks
+func Open(path string) (*Store, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		file:  file,
+		index: map[string]int64{},
+	}
+	err = s.scan()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+//scan indexes every complete record of the log and truncates the log after the last one
+func (s *Store) scan() error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	for {
+		block, size, err := s.readAt(s.size)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			log.Printf("truncating block log %s at offset %d of %d: %s", s.file.Name(), s.size, info.Size(), err.Error())
+			return s.truncate()
+		}
+		hash, err := block.Hash()
+		if err != nil {
+			return err
+		}
+		s.index[hash] = s.size
+		s.size += size
+	}
+}
+
+//truncate drops everything after the last indexed record
+func (s *Store) truncate() error {
+	err := s.file.Truncate(s.size)
+	if err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+//readAt reads the record at offset. Returns the block and the size of the record,
+//io.EOF if the log ends at offset and an error if the record is incomplete or corrupted
+func (s *Store) readAt(offset int64) (*blockchain.Block, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	n, err := s.file.ReadAt(header, offset)
+	if n == 0 && err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	if n < recordHeaderSize {
+		return nil, 0, errors.New("incomplete record header")
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length > maxRecordSize {
+		return nil, 0, fmt.Errorf("record length %d too large", length)
+	}
+	data := make([]byte, length)
+	n, err = s.file.ReadAt(data, offset+recordHeaderSize)
+	if n < len(data) {
+		return nil, 0, errors.New("incomplete record")
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+	block, err := serialization.DecodeToBlock(data)
+	if err != nil {
+		return nil, 0, err
+	}
+	return block, recordHeaderSize + int64(length), nil
+}
+
+//Append writes the block at the end of the log and syncs it to disk. Blocks already in the log are skipped
+func (s *Store) Append(block *blockchain.Block) error {
+	hash, err := block.Hash()
+	if err != nil {
+		return err
+	}
+	data, err := serialization.EncodeToBytes(block)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:recordHeaderSize], crc32.ChecksumIEEE(data))
+	copy(record[recordHeaderSize:], data)
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, ok := s.index[hash]; ok {
+		return nil
+	}
+	_, err = s.file.WriteAt(record, s.size)
+	if err != nil {
+		//drop the partial record so that the next append does not follow it
+		s.truncate()
+		return err
+	}
+	err = s.file.Sync()
+	if err != nil {
+		return err
+	}
+	s.index[hash] = s.size
+	s.size += int64(len(record))
+	return nil
+}
+
+//Has reports whether the block with the given hash is in the log
+func (s *Store) Has(hash string) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	_, ok := s.index[hash]
+	return ok
+}
+
+//Get reads the block with the given hash from the log, nil if it is not in the log
+func (s *Store) Get(hash string) (*blockchain.Block, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	offset, ok := s.index[hash]
+	if !ok {
+		return nil, nil
+	}
+	block, _, err := s.readAt(offset)
+	return block, err
+}
+
+//Len returns the number of blocks in the log
+func (s *Store) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return len(s.index)
+}
+
+//Replay calls apply with every block of the log in the order they were appended, stopping at the first error.
+//apply must not call the methods of the store
+func (s *Store) Replay(apply func(block *blockchain.Block) error) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for offset := int64(0); offset < s.size; {
+		block, size, err := s.readAt(offset)
+		if err != nil {
+			return err
+		}
+		err = apply(block)
+		if err != nil {
+			return err
+		}
+		offset += size
+	}
+	return nil
+}
+
+//Close closes the log
+func (s *Store) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.file.Close()
+}
@@ -0,0 +1,124 @@
+package blockstore_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/blockstore"
+)
+
+func testChain(t *testing.T, n int) []*blockchain.Block {
+	blocks := []*blockchain.Block{}
+	prevHash := ""
+	for i := 0; i < n; i++ {
+		block := &blockchain.Block{
+			Version:   blockchain.HeaderVersion,
+			PrevHash:  prevHash,
+			MinerID:   "a",
+			Timestamp: int64(i),
+		}
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks = append(blocks, block)
+		prevHash = hash
+	}
+	return blocks
+}
+
+func replayHashes(t *testing.T, store *blockstore.Store) []string {
+	hashes := []string{}
+	err := store.Replay(func(block *blockchain.Block) error {
+		hash, err := block.Hash()
+		hashes = append(hashes, hash)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hashes
+}
+
+func TestBlockStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.blocks")
+	store, err := blockstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := testChain(t, 3)
+	for _, block := range append(blocks, blocks[1]) {
+		err = store.Append(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+	store, err = blockstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	hashes := replayHashes(t, store)
+	if len(hashes) != 3 {
+		t.Fatalf("Expected 3 stored blocks, got %d", len(hashes))
+	}
+	for i, block := range blocks {
+		hash, _ := block.Hash()
+		if hashes[i] != hash {
+			t.Error("Blocks should be replayed in the order they were appended")
+		}
+		stored, err := store.Get(hash)
+		if err != nil || stored == nil || stored.Timestamp != block.Timestamp {
+			t.Error("Stored block should be found by its hash")
+		}
+	}
+}
+
+func TestBlockStoreTornWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.blocks")
+	store, err := blockstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := testChain(t, 3)
+	for _, block := range blocks[:2] {
+		err = store.Append(block)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//half of the last record made it to disk
+	err = os.Truncate(path, info.Size()-10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err = blockstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replayHashes(t, store)) != 1 {
+		t.Error("Torn record should be dropped")
+	}
+	err = store.Append(blocks[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	store, err = blockstore.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	hash, _ := blocks[2].Hash()
+	if len(replayHashes(t, store)) != 2 || !store.Has(hash) {
+		t.Error("Blocks appended after a torn record should be stored")
+	}
+}
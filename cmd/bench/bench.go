@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/blockchain"
+	"github.com/KostasAronis/go-rfs/hashing"
+	"github.com/KostasAronis/go-rfs/minerconfig"
+	"github.com/KostasAronis/go-rfs/rfslib"
+	"github.com/KostasAronis/go-rfs/uuid"
+)
+
+//Mines synthetic op and noop blocks with the proof of work of the miners and reports how fast nonces are found,
+//to pick the PowPerOpBlock and PowPerNoOpBlock of a network. Every flag not given is taken from -config if given.
+//
+//Usage:
+//
+//	./bench [-config config.json] [-alg SHA-256] [-opdiff 16] [-noopdiff 16] [-workers 1,2,4] [-ops 1,100] [-blocks 20] [-confirms 2] [-miners 1]
+func main() {
+	configFile := flag.String("config", "", "miner config to take the defaults from")
+	alg := flag.String("alg", "SHA-256", "hash algorithm of the blocks: MD5, SHA-256 or BLAKE2b")
+	opDiff := flag.Int("opdiff", 16, "op block difficulty in leading zero bits")
+	noopDiff := flag.Int("noopdiff", 16, "noop block difficulty in leading zero bits")
+	workers := flag.String("workers", strconv.Itoa((&minerconfig.Config{}).Workers()), "comma separated mining worker counts")
+	ops := flag.String("ops", "1", "comma separated op counts of the op blocks")
+	blocks := flag.Int("blocks", 20, "blocks mined per run")
+	confirms := flag.Int("confirms", 2, "ConfirmsPerFileAppend of the confirmation latency")
+	opTimeout := flag.Int("optimeout", 0, "GenOpBlockTimeout in milliseconds of the confirmation latency")
+	miners := flag.Int("miners", 1, "number of miners as fast as this one mining concurrently")
+	flag.Parse()
+	if *configFile != "" {
+		config, err := loadConfig(*configFile)
+		if err != nil {
+			panic(err)
+		}
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		defaults := map[string]string{
+			"alg":       config.CommonMinerConfig.HashAlgorithm,
+			"opdiff":    strconv.Itoa(config.CommonMinerConfig.PowPerOpBlock),
+			"noopdiff":  strconv.Itoa(config.CommonMinerConfig.PowPerNoOpBlock),
+			"workers":   strconv.Itoa(config.Workers()),
+			"confirms":  strconv.Itoa(config.CommonMinerConfig.ConfirmsPerFileAppend),
+			"optimeout": strconv.Itoa(config.CommonMinerConfig.GenOpBlockTimeout),
+			"miners":    strconv.Itoa(len(config.PeerMiners) + 1),
+		}
+		for name, value := range defaults {
+			if !set[name] {
+				flag.Set(name, value)
+			}
+		}
+	}
+	algorithm, err := hashing.Parse(*alg)
+	if err != nil {
+		panic(err)
+	}
+	workerCounts, err := parseCounts(*workers)
+	if err != nil {
+		panic(err)
+	}
+	opCounts, err := parseCounts(*ops)
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("%s, op difficulty %d, noop difficulty %d, %d blocks per run\n", algorithm, *opDiff, *noopDiff, *blocks)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "workers\tblock\tops\thashes/s\tmean\tp50\tp90\tp99\tappend latency\t")
+	for _, workerCount := range workerCounts {
+		noop := run(algorithm, *opDiff, *noopDiff, workerCount, false, 0, *blocks)
+		fmt.Fprintf(w, "%d\tnoop\t-\t%s\t-\t\n", workerCount, noop)
+		for _, opCount := range opCounts {
+			op := run(algorithm, *opDiff, *noopDiff, workerCount, true, opCount, *blocks)
+			latency := appendLatency(op, noop, *confirms, *opTimeout, *miners)
+			fmt.Fprintf(w, "%d\top\t%d\t%s\t%s\t\n", workerCount, opCount, op, latency.Round(time.Millisecond))
+		}
+	}
+	w.Flush()
+}
+
+func loadConfig(filename string) (*minerconfig.Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	config := &minerconfig.Config{}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+func parseCounts(list string) ([]int, error) {
+	counts := []int{}
+	for _, s := range strings.Split(list, ",") {
+		count, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("negative count %d", count)
+		}
+		counts = append(counts, count)
+	}
+	return counts, nil
+}
+
+//result the times to block and the hash rate of a run
+type result struct {
+	times  []time.Duration
+	hashes uint64
+	total  time.Duration
+}
+
+func (r *result) mean() time.Duration {
+	return r.total / time.Duration(len(r.times))
+}
+
+//percentile returns the smallest time to block not exceeded by the given fraction of the blocks
+func (r *result) percentile(p float64) time.Duration {
+	sorted := append([]time.Duration{}, r.times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+func (r *result) String() string {
+	rate := float64(r.hashes) / r.total.Seconds()
+	return fmt.Sprintf("%.0f\t%s\t%s\t%s\t%s", rate, round(r.mean()), round(r.percentile(0.5)), round(r.percentile(0.9)), round(r.percentile(0.99)))
+}
+
+func round(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+//run seals the given number of blocks on the genesis block of a new tree with the proof of work of the miners
+func run(algorithm hashing.Algorithm, opDiff int, noopDiff int, workers int, isOp bool, opCount int, blocks int) *result {
+	genesis := &blockchain.Block{Version: blockchain.HeaderVersion, HashAlgorithm: algorithm, MinerID: "0"}
+	tree := &blockchain.BlockTree{
+		GenesisNode:   genesis,
+		Blocks:        []*blockchain.Block{genesis},
+		OpDiff:        opDiff,
+		NoopDiff:      noopDiff,
+		HashAlgorithm: algorithm,
+	}
+	pow := blockchain.NewProofOfWork(tree, workers)
+	tree.SetConsensus(pow)
+	err := tree.Init()
+	if err != nil {
+		panic(err)
+	}
+	parent := tree.GetLastNode()
+	r := &result{}
+	stop := make(chan struct{})
+	for i := 0; i < blocks; i++ {
+		block := &blockchain.Block{
+			Version:       blockchain.HeaderVersion,
+			HashAlgorithm: algorithm,
+			PrevHash:      parent.Hash,
+			MinerID:       "bench",
+			IsOp:          isOp,
+			Timestamp:     time.Now().UnixNano(),
+			Ops:           syntheticOps(opCount),
+			PublicKey:     make([]byte, 32),
+		}
+		start := time.Now()
+		pow.Seal(block, parent, stop)
+		elapsed := time.Since(start)
+		r.times = append(r.times, elapsed)
+		r.total += elapsed
+	}
+	r.hashes = pow.Hashes()
+	return r
+}
+
+//syntheticOps returns the given number of AppendRec ops with full records
+func syntheticOps(count int) []*blockchain.OpRecord {
+	ops := []*blockchain.OpRecord{}
+	record := &rfslib.Record{}
+	for i := range record {
+		record[i] = byte(i)
+	}
+	for i := 0; i < count; i++ {
+		id, err := uuid.New()
+		if err != nil {
+			panic(err)
+		}
+		ops = append(ops, &blockchain.OpRecord{
+			MinerID:         "bench",
+			Timestamp:       time.Now(),
+			UUID:            id,
+			OpType:          blockchain.AppendRec,
+			Filename:        "bench",
+			Record:          record,
+			ClientKey:       make([]byte, 32),
+			ClientSignature: make([]byte, 64),
+		})
+	}
+	return ops
+}
+
+//appendLatency the expected time from an AppendRec to its confirmation: the op waits for its op block,
+//which then needs confirms blocks after it. With several miners as fast as this one a block is found
+//by the first of them, so the expected block times divide by the number of miners
+func appendLatency(op *result, noop *result, confirms int, opTimeout int, miners int) time.Duration {
+	if miners < 1 {
+		miners = 1
+	}
+	opBlock := op.mean() / time.Duration(miners)
+	noopBlock := noop.mean() / time.Duration(miners)
+	return time.Duration(opTimeout)*time.Millisecond + opBlock + time.Duration(confirms)*noopBlock
+}
@@ -25,6 +25,8 @@ The possible actions are:
 		head	k fname	 	:outputs the first k records in fname to stdout.
 		append	fname str	:appends a new string to fname.
 		touch	fname	 	:creates a blank file fname.
+		rm	fname	 	:deletes fname and all of its records.
+		mv	fname newname	:renames fname to newname.
 `
 }
 
@@ -129,6 +131,19 @@ func handleAction(args ...string) error {
 		if err != nil {
 			return err
 		}
+	case "rm":
+		filename := args[2]
+		err := remove(filename)
+		if err != nil {
+			return err
+		}
+	case "mv":
+		filename := args[2]
+		newName := args[3]
+		err := rename(filename, newName)
+		if err != nil {
+			return err
+		}
 	default:
 		help()
 		return nil
@@ -150,22 +165,18 @@ func saveAndExit() error {
 	log.Printf("Stored in file: %s", filename)
 	return nil
 }
+//getRecords reads the records through rfslib, which waits for the miner to push them
 func getRecords(filename string, indexes []int) error {
-	msg := tcp.Msg{
-		MSGType: tcp.ReadRec,
-		Payload: map[string]interface{}{
-			"Filename": filename,
-			"Record":   indexes,
-		},
-	}
-	res, err := send(&msg)
+	rfs, err := signingClient()
 	if err != nil {
 		return err
 	}
-	recArr := res.([]interface{})
-	for _, rec := range recArr {
+	for _, idx := range indexes {
 		r := rfslib.Record{}
-		r.FromFloatArrayInterface(rec)
+		err = rfs.ReadRec(filename, uint16(idx), &r)
+		if err != nil {
+			return err
+		}
 		log.Println(r.ToString())
 	}
 	return nil
@@ -197,47 +208,84 @@ func listFiles() error {
 	return nil
 }
 
+//clientKeyFile the key signing the ops of the client, kept so that the client stays the owner of its files
+const clientKeyFile = "client.key"
+
+//signingClient connects to the miner through rfslib, which signs the ops changing the files
+func signingClient() (rfslib.NamespaceRFS, error) {
+	key, err := rfslib.LoadOrCreateKey(clientKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	rfs, err := rfslib.InitializeWithKey("", ":8001", key)
+	if err != nil {
+		return nil, err
+	}
+	return rfs.(rfslib.NamespaceRFS), nil
+}
+
 func appendRec(filename string, record string) error {
-	msg := tcp.Msg{
-		MSGType: tcp.AppendRec,
-		Payload: map[string]interface{}{
-			"Filename": filename,
-			"Record":   strToRec(record),
-		},
+	rfs, err := signingClient()
+	if err != nil {
+		return err
 	}
-	res, err := send(&msg)
+	idx, err := rfs.AppendRec(filename, strToRec(record))
 	if err != nil {
 		return err
 	}
-	log.Println(res)
+	log.Println(idx)
 	return nil
 }
 func touch(filename string) error {
-	msg := tcp.Msg{
-		MSGType: tcp.CreateFile,
-		Payload: map[string]interface{}{
-			"Filename": filename,
-			"Record":   strToRec(""),
-		},
+	rfs, err := signingClient()
+	if err != nil {
+		return err
 	}
-	res, err := send(&msg)
+	err = rfs.CreateFile(filename)
 	if err != nil {
 		return err
 	}
-	log.Println(res)
+	log.Println("OpAdded")
+	return nil
+}
+func remove(filename string) error {
+	rfs, err := signingClient()
+	if err != nil {
+		return err
+	}
+	err = rfs.DeleteFile(filename)
+	if err != nil {
+		return err
+	}
+	log.Println("OpAdded")
+	return nil
+}
+func rename(filename string, newName string) error {
+	rfs, err := signingClient()
+	if err != nil {
+		return err
+	}
+	err = rfs.RenameFile(filename, newName)
+	if err != nil {
+		return err
+	}
+	log.Println("OpAdded")
 	return nil
 }
 
 func send(msg *tcp.Msg) (interface{}, error) {
 	c := tcp.Client{
 		ID:         "c_1",
-		Address:    "who cares",
 		TargetAddr: ":8001",
 		TargetID:   "1",
 	}
-	res := c.Send(msg, "")
+	defer c.Close()
+	res, err := c.Send(msg, "")
+	if err != nil {
+		return nil, err
+	}
 	if res.MSGType == tcp.Error {
-		return nil, fmt.Errorf(res.Payload.(string))
+		return nil, rfslib.ErrorFromPayload(res.Payload)
 	}
 	return res.Payload, nil
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/KostasAronis/go-rfs/blockchain"
 	"github.com/KostasAronis/go-rfs/serialization"
@@ -70,6 +71,7 @@ func createGraph(blockChain *blockchain.BlockTree) *gographviz.Graph {
 			attrs["color"] = "red"
 		}
 		attrs["fontcolor"] = idToColor[block.MinerID]
+		attrs["label"] = esc(label(block, h))
 
 		g.AddNode("G", esc(h), attrs)
 	}
@@ -85,6 +87,14 @@ func createGraph(blockChain *blockchain.BlockTree) *gographviz.Graph {
 	return g
 }
 
+//label shows the start of the hash and the timestamp of the block
+func label(block *blockchain.Block, hash string) string {
+	if block.Timestamp == 0 {
+		return hash[:8]
+	}
+	return hash[:8] + "\\n" + time.Unix(0, block.Timestamp).UTC().Format("2006-01-02 15:04:05.000")
+}
+
 func esc(h string) string {
 	return "\"" + h + "\""
 }
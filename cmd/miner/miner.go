@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/KostasAronis/go-rfs/blockchain/miner"
 	"github.com/KostasAronis/go-rfs/filesystem"
@@ -28,6 +30,12 @@ func main() {
 	}
 	log.SetPrefix("Miner " + config.MinerID + ": ")
 	log.SetFlags(log.LstdFlags | log.LUTC | log.Lmsgprefix | log.Lshortfile)
+	err = config.LoadOrCreateKey(filepath.Dir(configFilepath))
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("public key: %s", hex.EncodeToString(config.PublicKey()))
+	config.ResolvePaths(filepath.Dir(configFilepath))
 	m := miner.New(&config)
 	err = m.Start()
 	if err != nil {
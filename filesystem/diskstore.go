@@ -0,0 +1,87 @@
+package filesystem
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+//recordSize the size of a record in the record logs
+const recordSize = len(rfslib.Record{})
+
+//recordLogExt the extension of the record log files
+const recordLogExt = ".records"
+
+//diskLogs keeps the record logs in files in dir, named by the hex encoded file name
+type diskLogs struct {
+	m    sync.Mutex
+	dir  string
+	logs map[string]*recordLog
+}
+
+type recordLog struct {
+	file  *os.File
+	count int64
+}
+
+func (l *diskLogs) append(name string, record *rfslib.Record) (int64, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	log, ok := l.logs[name]
+	if !ok {
+		path := filepath.Join(l.dir, hex.EncodeToString([]byte(name))+recordLogExt)
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return -1, err
+		}
+		log = &recordLog{file: file}
+		l.logs[name] = log
+	}
+	_, err := log.file.WriteAt(record[:], log.count*int64(recordSize))
+	if err != nil {
+		return -1, err
+	}
+	log.count++
+	return log.count - 1, nil
+}
+
+func (l *diskLogs) read(name string, position int64) (*rfslib.Record, error) {
+	l.m.Lock()
+	log, ok := l.logs[name]
+	l.m.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no record log for file [%s]", name)
+	}
+	record := &rfslib.Record{}
+	_, err := log.file.ReadAt(record[:], position*int64(recordSize))
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+//NewDiskStore returns a store keeping the records in record logs in dir. The logs are not a persistent copy of
+//the files but storage for records that do not fit in memory, any logs left in dir by a previous store are replaced.
+//Records are not reclaimed from the logs since snapshots may still reference them, only their positions are reused,
+//see recordLogs. The records appended to snapshots are kept in memory, see Store
+func NewDiskStore(dir string) (Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	old, err := filepath.Glob(filepath.Join(dir, "*"+recordLogExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range old {
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newStore(&diskLogs{dir: dir, logs: map[string]*recordLog{}}), nil
+}
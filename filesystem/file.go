@@ -1,22 +1,27 @@
 package filesystem
 
-import "github.com/KostasAronis/go-rfs/rfslib"
+import (
+	"bytes"
+)
 
+//File the name and the permissions of a file, its records are kept by the Store of the FileSystem
 type File struct {
-	Name    string
-	Records []*rfslib.Record
+	Name string
+	//Owner the public key of the client that created the file
+	Owner []byte
+	//Writers the public keys of the clients allowed to append to the file besides its owner
+	Writers [][]byte
 }
 
-//AddRecord adds the content given as a record to the file and returns the index of the added record
-func (f *File) AddRecord(record *rfslib.Record) int {
-	f.Records = append(f.Records, record)
-	return len(f.Records) - 1
-}
-
-func (f *File) GetRecord(idx int) *rfslib.Record {
-	return f.Records[idx]
-}
-
-func (f *File) GetRecords() []*rfslib.Record {
-	return f.Records
+//CanWrite reports whether the client with the given public key may append to the file
+func (f *File) CanWrite(key []byte) bool {
+	if bytes.Equal(f.Owner, key) {
+		return true
+	}
+	for _, writer := range f.Writers {
+		if bytes.Equal(writer, key) {
+			return true
+		}
+	}
+	return false
 }
@@ -1,104 +1,240 @@
 package filesystem
 
-/*
-	filesystem
-	TODO:
-	1) Either abstract the whole filesystem or inject an abstract storage layer
-	2) Does it need a sync Map instead o plain map
-*/
-
 import (
+	"bytes"
+	"errors"
 	"sync"
 
 	"github.com/KostasAronis/go-rfs/rfslib"
 )
 
-//FileSystem represents an in memory filesystem
+//FileSystem represents a filesystem whose files are kept in a Store
 type FileSystem struct {
-	Files map[string]*File
-	m     sync.RWMutex
+	store Store
+	//maxRecords the maximum number of records of a file
+	maxRecords int
+	m          sync.RWMutex
+	//appended the channels closed (and removed) when a record is added to a file, by file name
+	appended map[string]chan struct{}
 }
 
+//New returns a filesystem without files kept in the given store, whose files hold at most maxRecords records
+func New(store Store, maxRecords int) *FileSystem {
+	return &FileSystem{
+		store:      store,
+		maxRecords: maxRecords,
+		appended:   map[string]chan struct{}{},
+	}
+}
+
+//Init initializes an empty filesystem kept in memory, whose files hold at most rfslib.MaxRecordsPerFile records
 func (f *FileSystem) Init() {
-	f.Files = make(map[string]*File)
-	f.m = sync.RWMutex{}
+	f.store = NewMemoryStore()
+	f.maxRecords = rfslib.MaxRecordsPerFile
+	f.appended = map[string]chan struct{}{}
 }
 
+//Clone returns a filesystem with a copy on write snapshot of the files of f, kept in the same kind of store
 func (f *FileSystem) Clone() *FileSystem {
-	f.m.RLock()
-	defer f.m.RUnlock()
-	clone := FileSystem{
-		Files: make(map[string]*File),
-	}
-	for k, v := range f.Files {
-		cloneFile := &File{
-			Name:    v.Name,
-			Records: []*rfslib.Record{},
-		}
-		for _, r := range v.Records {
-			cloneR := rfslib.Record(*r)
-			cloneFile.Records = append(cloneFile.Records, &cloneR)
-		}
-		clone.Files[k] = cloneFile
-	}
-	return &clone
+	f.m.Lock()
+	defer f.m.Unlock()
+	return New(f.store.Snapshot(), f.maxRecords)
 }
 
 //AddFile adds a file without records (touch)
 func (f *FileSystem) AddFile(fName string) (*File, error) {
+	return f.AddOwnedFile(fName, nil, nil)
+}
+
+//AddOwnedFile adds a file without records that can only be appended to by its owner and writers
+func (f *FileSystem) AddOwnedFile(fName string, owner []byte, writers [][]byte) (*File, error) {
+	err := rfslib.CheckFilename(fName)
+	if err != nil {
+		return nil, err
+	}
 	f.m.Lock()
 	defer f.m.Unlock()
-	if _, exists := f.Files[fName]; exists {
-		return nil, rfslib.FileExistsError(fName)
-	}
-	file := File{
+	file := &File{
 		Name:    fName,
-		Records: []*rfslib.Record{},
+		Owner:   owner,
+		Writers: writers,
 	}
-	f.Files[fName] = &file
-	return &file, nil
+	err = f.store.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+//CheckWriter returns an error unless the client with the given public key may append to the file
+func (f *FileSystem) CheckWriter(fName string, key []byte) error {
+	f.m.RLock()
+	defer f.m.RUnlock()
+	file, err := f.store.File(fName)
+	if err != nil {
+		return err
+	}
+	if !file.CanWrite(key) {
+		return rfslib.NotAuthorizedError(fName)
+	}
+	return nil
 }
 
 //AppendRecord adds the content given as a record to the file and returns the index of the added record
 func (f *FileSystem) AppendRecord(fName string, record *rfslib.Record) (int, error) {
 	f.m.Lock()
 	defer f.m.Unlock()
-	file, exists := f.Files[fName]
-	if !exists {
-		return -1, rfslib.FileDoesNotExistError(fName)
+	count, err := f.store.Count(fName)
+	if err != nil {
+		return -1, err
 	}
-	idx := file.AddRecord(record)
+	if count >= f.maxRecords {
+		return -1, rfslib.FileMaxLenReachedError(fName)
+	}
+	idx, err := f.store.Append(fName, record)
+	if err != nil {
+		return -1, err
+	}
+	f.notify(fName)
 	return idx, nil
 }
 
+//notify wakes up the WaitRecord calls on the file to check it again
+func (f *FileSystem) notify(fName string) {
+	if appended, ok := f.appended[fName]; ok {
+		close(appended)
+		delete(f.appended, fName)
+	}
+}
+
+//CheckOwner returns an error unless the client with the given public key is the owner of the file
+func (f *FileSystem) CheckOwner(fName string, key []byte) error {
+	f.m.RLock()
+	defer f.m.RUnlock()
+	file, err := f.store.File(fName)
+	if err != nil {
+		return err
+	}
+	if file.Owner == nil || !bytes.Equal(file.Owner, key) {
+		return rfslib.NotOwnerError(fName)
+	}
+	return nil
+}
+
+//DeleteFile deletes a file, keeping its records in a tombstone so that RestoreFile can undo the deletion
+func (f *FileSystem) DeleteFile(fName string) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	err := f.store.Delete(fName)
+	if err != nil {
+		return err
+	}
+	f.notify(fName)
+	return nil
+}
+
+//RestoreFile undoes the last DeleteFile of the file
+func (f *FileSystem) RestoreFile(fName string) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.store.Undelete(fName)
+}
+
+//RenameFile renames a file keeping its records, its owner and its writers
+func (f *FileSystem) RenameFile(fName string, newName string) error {
+	if newName == "" {
+		return rfslib.BadFilenameError(newName)
+	}
+	err := rfslib.CheckFilename(newName)
+	if err != nil {
+		return err
+	}
+	f.m.Lock()
+	defer f.m.Unlock()
+	err = f.store.Rename(fName, newName)
+	if err != nil {
+		return err
+	}
+	f.notify(fName)
+	return nil
+}
+
+//RemoveFile removes a file and all of its records
+func (f *FileSystem) RemoveFile(fName string) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	err := f.store.Remove(fName)
+	if err != nil {
+		return err
+	}
+	f.notify(fName)
+	return nil
+}
+
+//RemoveLastRecord removes the last appended record of the file
+func (f *FileSystem) RemoveLastRecord(fName string) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	err := f.store.RemoveLast(fName)
+	if err != nil {
+		return err
+	}
+	f.notify(fName)
+	return nil
+}
+
 //ListFiles returns a slice of all filenames currently in the filesystem
 func (f *FileSystem) ListFiles() []string {
 	f.m.RLock()
 	defer f.m.RUnlock()
-	fileNames := make([]string, 0, len(f.Files))
-	for k := range f.Files {
-		fileNames = append(fileNames, k)
-	}
-	return fileNames
+	return f.store.List()
 }
 
 func (f *FileSystem) TotalRecords(fName string) (int, error) {
 	f.m.RLock()
 	defer f.m.RUnlock()
-	if file, exists := f.Files[fName]; !exists {
-		return -1, rfslib.FileDoesNotExistError(fName)
-	} else {
-		records := file.GetRecords()
-		return len(records), nil
-	}
+	return f.store.Count(fName)
 }
 
+//ErrWaitCancelled returned by WaitRecord when the wait is cancelled before the record exists
+var ErrWaitCancelled = errors.New("wait for record cancelled")
+
+//ReadRecord returns the record at idx of the file, without blocking if it does not exist yet
 func (f *FileSystem) ReadRecord(fName string, idx int) (*rfslib.Record, error) {
 	f.m.RLock()
 	defer f.m.RUnlock()
-	file, exists := f.Files[fName]
-	if !exists {
-		return nil, rfslib.FileDoesNotExistError(fName)
+	return f.store.Read(fName, idx)
+}
+
+//WaitRecord returns the record at idx of the file, blocking until it is appended or cancel is closed.
+//Returns FileDoesNotExistError if the file is removed, deleted or renamed while waiting.
+//The record may not be committed on any chain, see blockchainfs.BlockchainFS.WaitRecord
+func (f *FileSystem) WaitRecord(fName string, idx int, cancel <-chan struct{}) (*rfslib.Record, error) {
+	if idx < 0 {
+		return nil, recordNotFound(fName, idx)
+	}
+	for {
+		f.m.Lock()
+		count, err := f.store.Count(fName)
+		if err != nil {
+			f.m.Unlock()
+			return nil, err
+		}
+		if idx < count {
+			record, err := f.store.Read(fName, idx)
+			f.m.Unlock()
+			return record, err
+		}
+		appended, ok := f.appended[fName]
+		if !ok {
+			appended = make(chan struct{})
+			f.appended[fName] = appended
+		}
+		f.m.Unlock()
+		select {
+		case <-appended:
+		case <-cancel:
+			return nil, ErrWaitCancelled
+		}
 	}
-	return file.GetRecord(idx), nil
 }
@@ -1,7 +1,9 @@
 package filesystem_test
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/KostasAronis/go-rfs/filesystem"
 	"github.com/KostasAronis/go-rfs/rfslib"
@@ -18,7 +20,7 @@ func TestAddFile(t *testing.T) {
 	if f1.Name != f1Name {
 		t.Error("Incorrect file name")
 	}
-	if len(fs.Files) != 1 {
+	if len(fs.ListFiles()) != 1 {
 		t.Error("File not added")
 	}
 	_, err = fs.AddFile(f1Name)
@@ -79,3 +81,157 @@ func TestAddRecord(t *testing.T) {
 		t.Error("TotalRecords of a file that does not exist should return idx == -1")
 	}
 }
+
+func TestWaitRecord(t *testing.T) {
+	fs := filesystem.FileSystem{}
+	fs.Init()
+	f1Name := "test1"
+	_, err := fs.WaitRecord(f1Name, 0, nil)
+	if _, ok := err.(rfslib.FileDoesNotExistError); !ok {
+		t.Error("Waiting on a file that does not exist should return FileDoesNotExistError")
+	}
+	fs.AddFile(f1Name)
+	done := make(chan *rfslib.Record)
+	go func() {
+		rec, err := fs.WaitRecord(f1Name, 1, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- rec
+	}()
+	rec0 := rfslib.Record([512]byte{})
+	copy(rec0[:], "first")
+	fs.AppendRecord(f1Name, &rec0)
+	select {
+	case <-done:
+		t.Fatal("WaitRecord should block until the record at the given index exists")
+	case <-time.After(50 * time.Millisecond):
+	}
+	rec1 := rfslib.Record([512]byte{})
+	copy(rec1[:], "second")
+	fs.AppendRecord(f1Name, &rec1)
+	select {
+	case rec := <-done:
+		if rec.ToString() != rec1.ToString() {
+			t.Error("WaitRecord returned incorrect record")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitRecord should return after the record is appended")
+	}
+	cancel := make(chan struct{})
+	close(cancel)
+	_, err = fs.WaitRecord(f1Name, 5, cancel)
+	if err != filesystem.ErrWaitCancelled {
+		t.Error("Cancelled WaitRecord should return ErrWaitCancelled")
+	}
+	removed := make(chan error)
+	go func() {
+		_, err := fs.WaitRecord(f1Name, 2, nil)
+		removed <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	fs.RemoveFile(f1Name)
+	select {
+	case err := <-removed:
+		if _, ok := err.(rfslib.FileDoesNotExistError); !ok {
+			t.Error("Waiting on a removed file should return FileDoesNotExistError")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("WaitRecord should return after the file is removed")
+	}
+}
+
+func TestRemove(t *testing.T) {
+	fs := filesystem.FileSystem{}
+	fs.Init()
+	f1Name := "test1"
+	fs.AddFile(f1Name)
+	rec := rfslib.Record([512]byte{})
+	fs.AppendRecord(f1Name, &rec)
+	fs.AppendRecord(f1Name, &rec)
+	err := fs.RemoveLastRecord(f1Name)
+	if err != nil {
+		t.Error(err)
+	}
+	recN, _ := fs.TotalRecords(f1Name)
+	if recN != 1 {
+		t.Error("RemoveLastRecord should remove exactly one record")
+	}
+	err = fs.RemoveFile(f1Name)
+	if err != nil {
+		t.Error(err)
+	}
+	if len(fs.ListFiles()) != 0 {
+		t.Error("RemoveFile should remove the file")
+	}
+	_, err = fs.AddFile(f1Name)
+	if err != nil {
+		t.Error("A removed file should be able to be created again")
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	fs := filesystem.FileSystem{}
+	fs.Init()
+	owner, writer, other := []byte("owner"), []byte("writer"), []byte("other")
+	_, err := fs.AddOwnedFile("shared", owner, [][]byte{writer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fs.CheckWriter("shared", owner) != nil || fs.CheckWriter("shared", writer) != nil {
+		t.Error("Owner and writers should be allowed to append")
+	}
+	_, correctErrorType := fs.CheckWriter("shared", other).(rfslib.NotAuthorizedError)
+	if !correctErrorType {
+		t.Error("Other clients should get NotAuthorizedError")
+	}
+	_, correctErrorType = fs.CheckWriter("missing", owner).(rfslib.FileDoesNotExistError)
+	if !correctErrorType {
+		t.Error("Checking a missing file should return FileDoesNotExistError")
+	}
+	if fs.Clone().CheckWriter("shared", writer) != nil {
+		t.Error("Clones should keep the owner and writers of files")
+	}
+	if fs.CheckOwner("shared", owner) != nil {
+		t.Error("The owner should be allowed to delete and rename")
+	}
+	_, correctErrorType = fs.CheckOwner("shared", writer).(rfslib.NotOwnerError)
+	if !correctErrorType {
+		t.Error("Writers should get NotOwnerError")
+	}
+	fs.AddFile("unowned")
+	_, correctErrorType = fs.CheckOwner("unowned", owner).(rfslib.NotOwnerError)
+	if !correctErrorType {
+		t.Error("Files without owner should not be deleted or renamed")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	fs := filesystem.New(filesystem.NewMemoryStore(), 2)
+	_, err := fs.AddFile(strings.Repeat("a", rfslib.MaxFilenameLen+1))
+	if _, ok := err.(rfslib.BadFilenameError); !ok {
+		t.Error("Adding a file with a name longer than 64 bytes should return BadFilenameError")
+	}
+	f1Name := strings.Repeat("a", rfslib.MaxFilenameLen)
+	_, err = fs.AddFile(f1Name)
+	if err != nil {
+		t.Error(err)
+	}
+	rec := rfslib.Record([512]byte{})
+	for i := 0; i < 2; i++ {
+		_, err = fs.AppendRecord(f1Name, &rec)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	idx, err := fs.AppendRecord(f1Name, &rec)
+	if _, ok := err.(rfslib.FileMaxLenReachedError); !ok || idx != -1 {
+		t.Error("Appending to a full file should return FileMaxLenReachedError")
+	}
+	if fs.RemoveLastRecord(f1Name) != nil || fs.Clone().RemoveLastRecord(f1Name) != nil {
+		t.Fatal("RemoveLastRecord failed")
+	}
+	if _, err = fs.AppendRecord(f1Name, &rec); err != nil {
+		t.Error("Appending should succeed again once the file is below its limit")
+	}
+}
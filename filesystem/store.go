@@ -0,0 +1,407 @@
+package filesystem
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+//Store the storage of the files and records of a FileSystem. The methods of a store are not safe for concurrent use,
+//FileSystem guards them with its lock. Errors about missing or existing files are the typed rfslib errors
+type Store interface {
+	//Create adds a file without records
+	Create(file *File) error
+	//Remove removes a file and all of its records
+	Remove(name string) error
+	//Delete removes a file, leaving a tombstone with its records so that Undelete can restore it
+	Delete(name string) error
+	//Undelete restores the last deleted file with the given name from its tombstone
+	Undelete(name string) error
+	//Rename moves a file with its records to newName
+	Rename(name string, newName string) error
+	//File returns the file with the given name, without its records
+	File(name string) (*File, error)
+	//Append adds a record at the end of the file and returns its index
+	Append(name string, record *rfslib.Record) (int, error)
+	//RemoveLast removes the last record of the file
+	RemoveLast(name string) error
+	//Read returns the record at idx of the file
+	Read(name string, idx int) (*rfslib.Record, error)
+	//Count returns the number of records of the file
+	Count(name string) (int, error)
+	//List returns the names of all files
+	List() []string
+	//Snapshot returns an independent store holding the current files, changes to either store do not affect the other.
+	//Snapshots are cheap but count as a change of the store for its callers' locking. They are meant as scratch copies:
+	//the records appended to a snapshot are kept in memory and dropped with it
+	Snapshot() Store
+}
+
+//recordNotFound the error of reading a record past the end of a file
+func recordNotFound(name string, idx int) error {
+	return fmt.Errorf("record %d does not exist in file [%s]", idx, name)
+}
+
+//recordLogs append only logs of records, one per file name, shared by a store and all of its snapshots.
+//A record is never changed or removed once appended, stores reference it by its position in the log of its file.
+//To keep the logs from growing with records that are appended again after they were removed, a store reuses
+//the position of a removed record when the same record is appended at its index, see storedFile
+type recordLogs interface {
+	append(name string, record *rfslib.Record) (int64, error)
+	read(name string, position int64) (*rfslib.Record, error)
+}
+
+//memoryLogs keeps the record logs in memory
+type memoryLogs struct {
+	m    sync.Mutex
+	logs map[string][]*rfslib.Record
+}
+
+func (l *memoryLogs) append(name string, record *rfslib.Record) (int64, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.logs[name] = append(l.logs[name], record)
+	return int64(len(l.logs[name]) - 1), nil
+}
+
+func (l *memoryLogs) read(name string, position int64) (*rfslib.Record, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.logs[name][position], nil
+}
+
+//scratchLogs the record logs of a snapshot and its own snapshots. Their records are kept in memory at negative
+//positions, so that discarded snapshots leave the logs of the store they were taken from as they were
+type scratchLogs struct {
+	shared  recordLogs
+	scratch *memoryLogs
+}
+
+func (l *scratchLogs) append(name string, record *rfslib.Record) (int64, error) {
+	position, err := l.scratch.append(name, record)
+	return -position - 1, err
+}
+
+func (l *scratchLogs) read(name string, position int64) (*rfslib.Record, error) {
+	if position < 0 {
+		return l.scratch.read(name, -position-1)
+	}
+	return l.shared.read(name, position)
+}
+
+//chunkSize the number of record positions per chunk
+const chunkSize = 1024
+
+//chunk a block of record positions shared by the versions of a file. Every slot is written once, by the first version
+//that claims it by raising used, so the slots below the record count of any version never change
+type chunk struct {
+	used      int32
+	positions [chunkSize]int64
+}
+
+//positions the positions of the records of a version of a file in the record log of its name
+type positions struct {
+	chunks []*chunk
+	count  int
+}
+
+func (p *positions) get(idx int) int64 {
+	return p.chunks[idx/chunkSize].positions[idx%chunkSize]
+}
+
+//append adds the position of a record after the last one. If the next slot of the last chunk
+//was claimed by another version, the version continues on a copy of the chunk
+func (p *positions) append(position int64) {
+	i, slot := p.count/chunkSize, p.count%chunkSize
+	if i == len(p.chunks) {
+		p.chunks = append(p.chunks, &chunk{})
+	}
+	c := p.chunks[i]
+	if !atomic.CompareAndSwapInt32(&c.used, int32(slot), int32(slot)+1) {
+		copied := &chunk{used: int32(slot) + 1}
+		copy(copied.positions[:slot], c.positions[:slot])
+		p.chunks[i] = copied
+		c = copied
+	}
+	c.positions[slot] = position
+	p.count++
+}
+
+//storedFile a version of a file. It may only be changed in place by the store that owns it, see store
+type storedFile struct {
+	file *File
+	//log the name of the record log of the file, the name it was created with
+	log       string
+	positions positions
+	//removed the positions of the removed records past the last one, the one at the next index last.
+	//They are reused while the same records are appended again, as when a chain is rewound and applied again
+	removed []int64
+	owner   uint64
+}
+
+//storeIDs the source of the ids of the stores
+var storeIDs uint64
+
+//store keeps the files in a map of versioned files and their records in record logs. Snapshots are copy on write:
+//a snapshot shares the map and the files with its store and both get new ids, so that the first change of the map
+//or of a file by either store copies it. Copying a file only copies its chunk list, never its records,
+//so a snapshot and the changes after it cost O(changed files + appended records)
+type store struct {
+	id    uint64
+	logs  recordLogs
+	files map[string]*storedFile
+	//tombstones the deleted files by name, the last deleted one last
+	tombstones map[string][]*storedFile
+	//sharedFiles whether files and tombstones are shared with a snapshot and must be copied before they are changed
+	sharedFiles bool
+	//removedFiles the positions of the records of the removed files by name, reused by a file created with the name
+	removedFiles map[string][]int64
+}
+
+func newStore(logs recordLogs) *store {
+	return &store{
+		id:           atomic.AddUint64(&storeIDs, 1),
+		logs:         logs,
+		files:        map[string]*storedFile{},
+		tombstones:   map[string][]*storedFile{},
+		removedFiles: map[string][]int64{},
+	}
+}
+
+//NewMemoryStore returns a store keeping every file and record in memory. Records are not reclaimed since snapshots
+//may still reference them, only their positions are reused, see recordLogs
+func NewMemoryStore() Store {
+	return newStore(&memoryLogs{logs: map[string][]*rfslib.Record{}})
+}
+
+func (s *store) get(name string) (*storedFile, error) {
+	file, exists := s.files[name]
+	if !exists {
+		return nil, rfslib.FileDoesNotExistError(name)
+	}
+	return file, nil
+}
+
+//ownFiles copies the file and tombstone maps if they are shared with a snapshot
+func (s *store) ownFiles() {
+	if !s.sharedFiles {
+		return
+	}
+	files := make(map[string]*storedFile, len(s.files))
+	for name, file := range s.files {
+		files[name] = file
+	}
+	tombstones := make(map[string][]*storedFile, len(s.tombstones))
+	for name, deleted := range s.tombstones {
+		tombstones[name] = deleted
+	}
+	s.files = files
+	s.tombstones = tombstones
+	s.sharedFiles = false
+}
+
+//own returns the file with the given name, copied into the store if it belongs to another one
+func (s *store) own(name string) (*storedFile, error) {
+	file, err := s.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if file.owner == s.id {
+		return file, nil
+	}
+	owned := &storedFile{
+		file: file.file,
+		log:  file.log,
+		positions: positions{
+			chunks: append([]*chunk{}, file.positions.chunks...),
+			count:  file.positions.count,
+		},
+		removed: append([]int64{}, file.removed...),
+		owner:   s.id,
+	}
+	s.ownFiles()
+	s.files[name] = owned
+	return owned, nil
+}
+
+func (s *store) Create(file *File) error {
+	if _, exists := s.files[file.Name]; exists {
+		return rfslib.FileExistsError(file.Name)
+	}
+	s.ownFiles()
+	s.files[file.Name] = &storedFile{file: file, log: file.Name, removed: s.removedFiles[file.Name], owner: s.id}
+	delete(s.removedFiles, file.Name)
+	return nil
+}
+
+func (s *store) Remove(name string) error {
+	file, err := s.get(name)
+	if err != nil {
+		return err
+	}
+	s.ownFiles()
+	delete(s.files, name)
+	if file.log == name {
+		removed := append([]int64{}, file.removed...)
+		for idx := file.positions.count - 1; idx >= 0; idx-- {
+			removed = append(removed, file.positions.get(idx))
+		}
+		s.removedFiles[name] = removed
+	}
+	return nil
+}
+
+func (s *store) Delete(name string) error {
+	file, err := s.get(name)
+	if err != nil {
+		return err
+	}
+	s.ownFiles()
+	delete(s.files, name)
+	//the stacks may be shared with snapshots, so they are never appended to in place
+	deleted := s.tombstones[name]
+	s.tombstones[name] = append(deleted[:len(deleted):len(deleted)], file)
+	return nil
+}
+
+func (s *store) Undelete(name string) error {
+	if _, exists := s.files[name]; exists {
+		return rfslib.FileExistsError(name)
+	}
+	deleted := s.tombstones[name]
+	if len(deleted) == 0 {
+		return rfslib.FileDoesNotExistError(name)
+	}
+	s.ownFiles()
+	s.files[name] = deleted[len(deleted)-1]
+	if len(deleted) == 1 {
+		delete(s.tombstones, name)
+	} else {
+		s.tombstones[name] = deleted[:len(deleted)-1]
+	}
+	return nil
+}
+
+func (s *store) Rename(name string, newName string) error {
+	file, err := s.get(name)
+	if err != nil {
+		return err
+	}
+	if _, exists := s.files[newName]; exists {
+		return rfslib.FileExistsError(newName)
+	}
+	s.ownFiles()
+	delete(s.files, name)
+	//the moved version keeps sharing the chunks of file, so it is not owned by any store
+	moved := *file
+	moved.file = &File{Name: newName, Owner: file.file.Owner, Writers: file.file.Writers}
+	moved.owner = 0
+	s.files[newName] = &moved
+	return nil
+}
+
+func (s *store) File(name string) (*File, error) {
+	file, err := s.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return file.file, nil
+}
+
+func (s *store) Append(name string, record *rfslib.Record) (int, error) {
+	file, err := s.own(name)
+	if err != nil {
+		return -1, err
+	}
+	position, err := s.reuse(file, record)
+	if err != nil {
+		return -1, err
+	}
+	if position == nil {
+		appended, err := s.logs.append(file.log, record)
+		if err != nil {
+			return -1, err
+		}
+		position = &appended
+	}
+	file.positions.append(*position)
+	return file.positions.count - 1, nil
+}
+
+//reuse returns the position of the removed record at the next index of the file if it is the same as record,
+//nil otherwise. Once a different record is appended none of the removed positions can be reused
+func (s *store) reuse(file *storedFile, record *rfslib.Record) (*int64, error) {
+	if len(file.removed) == 0 {
+		return nil, nil
+	}
+	position := file.removed[len(file.removed)-1]
+	removed, err := s.logs.read(file.log, position)
+	if err != nil {
+		return nil, err
+	}
+	if *removed != *record {
+		file.removed = nil
+		return nil, nil
+	}
+	file.removed = file.removed[:len(file.removed)-1]
+	return &position, nil
+}
+
+func (s *store) RemoveLast(name string) error {
+	file, err := s.own(name)
+	if err != nil {
+		return err
+	}
+	if file.positions.count == 0 {
+		return fmt.Errorf("file [%s] has no records", name)
+	}
+	file.positions.count--
+	file.removed = append(file.removed, file.positions.get(file.positions.count))
+	return nil
+}
+
+func (s *store) Read(name string, idx int) (*rfslib.Record, error) {
+	file, err := s.get(name)
+	if err != nil {
+		return nil, err
+	}
+	if idx < 0 || idx >= file.positions.count {
+		return nil, recordNotFound(name, idx)
+	}
+	return s.logs.read(file.log, file.positions.get(idx))
+}
+
+func (s *store) Count(name string) (int, error) {
+	file, err := s.get(name)
+	if err != nil {
+		return -1, err
+	}
+	return file.positions.count, nil
+}
+
+func (s *store) List() []string {
+	names := make([]string, 0, len(s.files))
+	for name := range s.files {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (s *store) Snapshot() Store {
+	s.id = atomic.AddUint64(&storeIDs, 1)
+	s.sharedFiles = true
+	logs, scratch := s.logs.(*scratchLogs)
+	if !scratch {
+		logs = &scratchLogs{shared: s.logs, scratch: &memoryLogs{logs: map[string][]*rfslib.Record{}}}
+	}
+	return &store{
+		id:           atomic.AddUint64(&storeIDs, 1),
+		logs:         logs,
+		files:        s.files,
+		tombstones:   s.tombstones,
+		sharedFiles:  true,
+		removedFiles: map[string][]int64{},
+	}
+}
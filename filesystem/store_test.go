@@ -0,0 +1,252 @@
+package filesystem_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KostasAronis/go-rfs/filesystem"
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+func testStores(t *testing.T) map[string]filesystem.Store {
+	disk, err := filesystem.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]filesystem.Store{
+		"memory": filesystem.NewMemoryStore(),
+		"disk":   disk,
+	}
+}
+
+func testRecord(s string) *rfslib.Record {
+	record := &rfslib.Record{}
+	copy(record[:], s)
+	return record
+}
+
+func TestStoreSnapshot(t *testing.T) {
+	for name, store := range testStores(t) {
+		fs := filesystem.New(store, rfslib.MaxRecordsPerFile)
+		fs.AddFile("f1")
+		fs.AppendRecord("f1", testRecord("a"))
+		fs.AppendRecord("f1", testRecord("b"))
+		clone := fs.Clone()
+		//both filesystems replace the last record and add a file of their own
+		fs.RemoveLastRecord("f1")
+		fs.AppendRecord("f1", testRecord("c"))
+		fs.AddFile("f2")
+		clone.RemoveLastRecord("f1")
+		clone.AppendRecord("f1", testRecord("d"))
+		clone.AppendRecord("f1", testRecord("e"))
+		clone.AddFile("f3")
+		if n, _ := fs.TotalRecords("f1"); n != 2 {
+			t.Errorf("%s: expected 2 records, got %d", name, n)
+		}
+		if n, _ := clone.TotalRecords("f1"); n != 3 {
+			t.Errorf("%s: expected 3 records in clone, got %d", name, n)
+		}
+		for i, expected := range []string{"a", "c"} {
+			record, err := fs.ReadRecord("f1", i)
+			if err != nil || *record != *testRecord(expected) {
+				t.Errorf("%s: record %d of the filesystem changed by its clone", name, i)
+			}
+		}
+		for i, expected := range []string{"a", "d", "e"} {
+			record, err := clone.ReadRecord("f1", i)
+			if err != nil || *record != *testRecord(expected) {
+				t.Errorf("%s: record %d of the clone changed by its filesystem", name, i)
+			}
+		}
+		if _, err := fs.ReadRecord("f3", 0); err == nil {
+			t.Errorf("%s: files added to the clone should not be in the filesystem", name)
+		}
+		if _, err := clone.ReadRecord("f1", 3); err == nil {
+			t.Errorf("%s: reading past the last record should fail", name)
+		}
+	}
+}
+
+//TestStoreForks checks chains of snapshots that append and remove records across chunks against plain slices
+func TestStoreForks(t *testing.T) {
+	for name, store := range testStores(t) {
+		fs := filesystem.New(store, rfslib.MaxRecordsPerFile)
+		fs.AddFile("f")
+		type fork struct {
+			fs      *filesystem.FileSystem
+			records []string
+		}
+		forks := []*fork{{fs: fs}}
+		for round := 0; round < 6; round++ {
+			for i, f := range forks {
+				//every fork drops some of its records and appends records of its own
+				for k := 0; k < i*300 && len(f.records) > 0; k++ {
+					f.fs.RemoveLastRecord("f")
+					f.records = f.records[:len(f.records)-1]
+				}
+				for k := 0; k < 700; k++ {
+					r := fmt.Sprintf("%d-%d-%d", round, i, k)
+					f.fs.AppendRecord("f", testRecord(r))
+					f.records = append(f.records, r)
+				}
+			}
+			last := forks[len(forks)-1]
+			forks = append(forks, &fork{fs: last.fs.Clone(), records: append([]string{}, last.records...)})
+		}
+		for i, f := range forks {
+			if n, _ := f.fs.TotalRecords("f"); n != len(f.records) {
+				t.Fatalf("%s: fork %d has %d records instead of %d", name, i, n, len(f.records))
+			}
+			for idx, expected := range f.records {
+				record, err := f.fs.ReadRecord("f", idx)
+				if err != nil || *record != *testRecord(expected) {
+					t.Fatalf("%s: record %d of fork %d changed by another fork", name, idx, i)
+				}
+			}
+		}
+	}
+}
+
+func TestStoreDeleteAndRename(t *testing.T) {
+	for name, store := range testStores(t) {
+		fs := filesystem.New(store, rfslib.MaxRecordsPerFile)
+		fs.AddFile("f1")
+		fs.AppendRecord("f1", testRecord("a"))
+		clone := fs.Clone()
+		if err := fs.DeleteFile("f1"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if _, err := fs.TotalRecords("f1"); err == nil {
+			t.Errorf("%s: a deleted file should not exist", name)
+		}
+		//a new file with the name of a deleted one starts empty and is deleted in turn
+		fs.AddFile("f1")
+		fs.AppendRecord("f1", testRecord("b"))
+		fs.AppendRecord("f1", testRecord("c"))
+		fs.DeleteFile("f1")
+		if err := fs.RestoreFile("f1"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if n, _ := fs.TotalRecords("f1"); n != 2 {
+			t.Errorf("%s: expected the last deleted file with 2 records, got %d", name, n)
+		}
+		fs.RemoveFile("f1")
+		if fs.RestoreFile("f1") != nil {
+			t.Fatalf("%s: the first deleted file should still be restorable", name)
+		}
+		if record, err := fs.ReadRecord("f1", 0); err != nil || *record != *testRecord("a") {
+			t.Errorf("%s: restored file should keep its records", name)
+		}
+		if _, ok := fs.RestoreFile("f1").(rfslib.FileExistsError); !ok {
+			t.Errorf("%s: restoring over an existing file should return FileExistsError", name)
+		}
+		//renamed files keep their records and can still be appended to
+		if err := fs.RenameFile("f1", "f2"); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		fs.AppendRecord("f2", testRecord("d"))
+		for i, expected := range []string{"a", "d"} {
+			record, err := fs.ReadRecord("f2", i)
+			if err != nil || *record != *testRecord(expected) {
+				t.Errorf("%s: record %d of the renamed file is wrong", name, i)
+			}
+		}
+		fs.AddFile("f1")
+		if _, ok := fs.RenameFile("f2", "f1").(rfslib.FileExistsError); !ok {
+			t.Errorf("%s: renaming to an existing file should return FileExistsError", name)
+		}
+		if _, ok := fs.RenameFile("f2", "").(rfslib.BadFilenameError); !ok {
+			t.Errorf("%s: renaming to an empty name should return BadFilenameError", name)
+		}
+		//none of the above should be visible in the clone
+		if n, _ := clone.TotalRecords("f1"); n != 1 {
+			t.Errorf("%s: expected 1 record in the clone, got %d", name, n)
+		}
+		if clone.RestoreFile("f1") == nil || len(clone.ListFiles()) != 1 {
+			t.Errorf("%s: the clone should not share the deleted files of its filesystem", name)
+		}
+		if record, err := clone.ReadRecord("f1", 0); err != nil || *record != *testRecord("a") {
+			t.Errorf("%s: record of the clone changed by its filesystem", name)
+		}
+	}
+}
+
+//TestStoreLogGrowth checks that the record logs of a disk store only grow with the records of the store itself,
+//not with the records of discarded snapshots or with records appended again after they were removed
+func TestStoreLogGrowth(t *testing.T) {
+	dir := t.TempDir()
+	store, err := filesystem.NewDiskStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logSize := func() int64 {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.records"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := int64(0)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			size += info.Size()
+		}
+		return size
+	}
+	fs := filesystem.New(store, rfslib.MaxRecordsPerFile)
+	fs.AddFile("f1")
+	for i := 0; i < 10; i++ {
+		fs.AppendRecord("f1", testRecord(fmt.Sprint(i)))
+	}
+	expected := logSize()
+	if expected != int64(10*len(rfslib.Record{})) {
+		t.Fatalf("expected a log of 10 records, got %d bytes", expected)
+	}
+	for cycle := 0; cycle < 20; cycle++ {
+		//a scratch clone rewinds and appends records of its own and is discarded
+		clone := fs.Clone()
+		clone.RemoveLastRecord("f1")
+		clone.AddFile("f2")
+		for i := 0; i < 10; i++ {
+			clone.AppendRecord("f1", testRecord(fmt.Sprintf("clone %d", i)))
+			clone.AppendRecord("f2", testRecord(fmt.Sprint(i)))
+		}
+		//the filesystem rewinds its last records and the file it created and applies them again
+		fs.AddFile("f3")
+		fs.AppendRecord("f3", testRecord("f3"))
+		for i := 0; i < 5; i++ {
+			fs.RemoveLastRecord("f1")
+		}
+		fs.RemoveLastRecord("f3")
+		fs.RemoveFile("f3")
+		for i := 5; i < 10; i++ {
+			fs.AppendRecord("f1", testRecord(fmt.Sprint(i)))
+		}
+		if cycle == 0 {
+			expected += int64(len(rfslib.Record{}))
+		}
+		if size := logSize(); size != expected {
+			t.Fatalf("cycle %d: expected the logs to stay at %d bytes, got %d", cycle, expected, size)
+		}
+		if record, err := clone.ReadRecord("f1", 9); err != nil || *record != *testRecord("clone 0") {
+			t.Fatalf("cycle %d: the clone lost its records", cycle)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if record, err := fs.ReadRecord("f1", i); err != nil || *record != *testRecord(fmt.Sprint(i)) {
+			t.Errorf("record %d changed", i)
+		}
+	}
+	//a different record after a rewind is appended to the log
+	fs.RemoveLastRecord("f1")
+	fs.AppendRecord("f1", testRecord("other"))
+	if size := logSize(); size != expected+int64(len(rfslib.Record{})) {
+		t.Errorf("expected a new record in the logs, got %d bytes", size)
+	}
+	if record, err := fs.ReadRecord("f1", 9); err != nil || *record != *testRecord("other") {
+		t.Errorf("expected the new record, got %v", err)
+	}
+}
@@ -5,4 +5,5 @@ go 1.15
 require (
 	github.com/DistributedClocks/GoVector v0.0.0-20210119215149-348aa425de2a
 	github.com/awalterschulze/gographviz v2.0.3+incompatible
+	golang.org/x/crypto v0.0.0-20220214200702-86341886e292
 )
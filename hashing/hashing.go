@@ -0,0 +1,87 @@
+//Package hashing provides the hash algorithms a network can use for its block hashes
+//and the leading zero bits difficulty of their proof of work.
+package hashing
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"math/bits"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+//Algorithm identifies the hash function of the block hashes, the zero value is MD5
+type Algorithm uint8
+
+const (
+	//MD5 the original hash function of the network, kept for backward compatibility
+	MD5 Algorithm = 0
+	//SHA256 SHA-256
+	SHA256 Algorithm = 1
+	//BLAKE2b unkeyed BLAKE2b with 256 bit digests as specified in RFC 7693
+	BLAKE2b Algorithm = 2
+)
+
+//names the configuration names of the algorithms
+var names = map[Algorithm]string{
+	MD5:     "MD5",
+	SHA256:  "SHA-256",
+	BLAKE2b: "BLAKE2b",
+}
+
+func (a Algorithm) String() string {
+	name, ok := names[a]
+	if !ok {
+		return fmt.Sprintf("Algorithm(%d)", uint8(a))
+	}
+	return name
+}
+
+//Parse returns the algorithm with the given configuration name, the empty name is MD5
+func Parse(name string) (Algorithm, error) {
+	if name == "" {
+		return MD5, nil
+	}
+	for a, n := range names {
+		if n == name {
+			return a, nil
+		}
+	}
+	return MD5, fmt.Errorf("unknown hash algorithm %s", name)
+}
+
+//Check returns an error for unknown algorithms
+func (a Algorithm) Check() error {
+	if _, ok := names[a]; !ok {
+		return fmt.Errorf("unknown hash algorithm %d", uint8(a))
+	}
+	return nil
+}
+
+//Sum returns the digest of data. Unknown algorithms must have been rejected with Check
+func (a Algorithm) Sum(data []byte) []byte {
+	switch a {
+	case SHA256:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	case BLAKE2b:
+		sum := blake2b.Sum256(data)
+		return sum[:]
+	default:
+		sum := md5.Sum(data)
+		return sum[:]
+	}
+}
+
+//LeadingZeroBits returns the number of leading zero bits of a digest
+func LeadingZeroBits(digest []byte) int {
+	n := 0
+	for _, b := range digest {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,75 @@
+package hashing_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/KostasAronis/go-rfs/hashing"
+)
+
+func TestBlake2b256(t *testing.T) {
+	long := []byte{}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 256; j++ {
+			long = append(long, byte(j))
+		}
+	}
+	vectors := []struct {
+		data   []byte
+		digest string
+	}{
+		{[]byte{}, "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		{[]byte("abc"), "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{make([]byte, 128), "378d0caaaa3855f1b38693c1d6ef004fd118691c95c959d4efa950d6d6fcf7c1"},
+		{make([]byte, 129), "baadfb64c3bd2cd187b54accc5e61a0720ed86bf48c28017873536cf9015d1b8"},
+		{long, "b8007121274217790e2923e0ad7027986e5a99d5531ef6ae7d294140fc81615d"},
+	}
+	for _, v := range vectors {
+		digest := hashing.BLAKE2b.Sum(v.data)
+		if hex.EncodeToString(digest) != v.digest {
+			t.Errorf("BLAKE2b of %d bytes should be %s, got %x", len(v.data), v.digest, digest)
+		}
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	for _, name := range []string{"MD5", "SHA-256", "BLAKE2b"} {
+		a, err := hashing.Parse(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.String() != name || a.Check() != nil {
+			t.Errorf("%s should be a known algorithm", name)
+		}
+	}
+	if a, err := hashing.Parse(""); err != nil || a != hashing.MD5 {
+		t.Error("The default algorithm should be MD5")
+	}
+	if _, err := hashing.Parse("SHA-1"); err == nil {
+		t.Error("Unknown algorithms should not parse")
+	}
+	if len(hashing.MD5.Sum(nil)) != 16 || len(hashing.SHA256.Sum(nil)) != 32 || len(hashing.BLAKE2b.Sum(nil)) != 32 {
+		t.Error("Digests should have the size of their algorithm")
+	}
+	if bytes.Equal(hashing.SHA256.Sum(nil), hashing.BLAKE2b.Sum(nil)) {
+		t.Error("Algorithms should compute different digests")
+	}
+}
+
+func TestLeadingZeroBits(t *testing.T) {
+	cases := []struct {
+		digest []byte
+		bits   int
+	}{
+		{[]byte{0x80, 0}, 0},
+		{[]byte{0x01, 0}, 7},
+		{[]byte{0, 0x10}, 11},
+		{[]byte{0, 0}, 16},
+	}
+	for _, c := range cases {
+		if n := hashing.LeadingZeroBits(c.digest); n != c.bits {
+			t.Errorf("%x should have %d leading zero bits, got %d", c.digest, c.bits, n)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+//Package merkle computes the merkle root of an ordered list of items and proofs that an item is part of it.
+//Leaves and inner nodes are hashed with different prefixes so that an inner node can not be passed off as a leaf,
+//and an odd node at the end of a level is promoted to the next level as is instead of being paired with itself.
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+//Size the size of the hashes of the tree in bytes
+const Size = sha256.Size
+
+const (
+	leafPrefix = 0
+	nodePrefix = 1
+)
+
+//LeafHash returns the hash of an item as a leaf of the tree
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+//NodeHash returns the hash of the inner node with the given children
+func NodeHash(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+//Root returns the merkle root of the given items. The root of no items is Size zero bytes
+func Root(items [][]byte) []byte {
+	if len(items) == 0 {
+		return make([]byte, Size)
+	}
+	level := make([][]byte, len(items))
+	for i, item := range items {
+		level[i] = LeafHash(item)
+	}
+	for len(level) > 1 {
+		level = nextLevel(level)
+	}
+	return level[0]
+}
+
+//Step a sibling on the path from a leaf to the root
+type Step struct {
+	Hash []byte
+	//Left whether the sibling is the left child of their parent
+	Left bool
+}
+
+//Proof returns the siblings on the path from the item at index to the root of items, nil if index is out of range.
+//Odd nodes promoted to the next level have no sibling so they add no step
+func Proof(items [][]byte, index int) []Step {
+	if index < 0 || index >= len(items) {
+		return nil
+	}
+	level := make([][]byte, len(items))
+	for i, item := range items {
+		level[i] = LeafHash(item)
+	}
+	proof := []Step{}
+	for len(level) > 1 {
+		if index%2 == 1 {
+			proof = append(proof, Step{Hash: level[index-1], Left: true})
+		} else if index+1 < len(level) {
+			proof = append(proof, Step{Hash: level[index+1], Left: false})
+		}
+		level = nextLevel(level)
+		index /= 2
+	}
+	return proof
+}
+
+//Verify checks that proof leads from item to root
+func Verify(item []byte, proof []Step, root []byte) bool {
+	hash := LeafHash(item)
+	for _, step := range proof {
+		if step.Left {
+			hash = NodeHash(step.Hash, hash)
+		} else {
+			hash = NodeHash(hash, step.Hash)
+		}
+	}
+	return bytes.Equal(hash, root)
+}
+
+//nextLevel hashes the nodes of a level in pairs
+func nextLevel(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, NodeHash(level[i], level[i+1]))
+	}
+	return next
+}
@@ -0,0 +1,57 @@
+package merkle_test
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/KostasAronis/go-rfs/merkle"
+)
+
+func testItems(n int) [][]byte {
+	items := [][]byte{}
+	for i := 0; i < n; i++ {
+		items = append(items, []byte(fmt.Sprintf("item %d", i)))
+	}
+	return items
+}
+
+func TestRoot(t *testing.T) {
+	if !bytes.Equal(merkle.Root(nil), make([]byte, merkle.Size)) {
+		t.Error("Root of no items should be zero")
+	}
+	items := testItems(3)
+	expected := merkle.NodeHash(merkle.NodeHash(merkle.LeafHash(items[0]), merkle.LeafHash(items[1])), merkle.LeafHash(items[2]))
+	if !bytes.Equal(merkle.Root(items), expected) {
+		t.Error("The odd item of a level should be promoted")
+	}
+	items[2] = []byte("changed")
+	if bytes.Equal(merkle.Root(items), expected) {
+		t.Error("Root should depend on every item")
+	}
+}
+
+func TestProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		items := testItems(n)
+		root := merkle.Root(items)
+		for i := range items {
+			proof := merkle.Proof(items, i)
+			if !merkle.Verify(items[i], proof, root) {
+				t.Errorf("Proof of item %d of %d should verify", i, n)
+			}
+			if merkle.Verify([]byte("other"), proof, root) {
+				t.Errorf("Proof of item %d of %d should not verify another item", i, n)
+			}
+		}
+	}
+	items := testItems(4)
+	//an inner node must not pass as a leaf
+	inner := append(merkle.LeafHash(items[2]), merkle.LeafHash(items[3])...)
+	if merkle.Verify(inner, merkle.Proof(items, 2)[1:], merkle.Root(items)) {
+		t.Error("Inner nodes should not verify as items")
+	}
+	if merkle.Proof(items, 4) != nil {
+		t.Error("Proof of an out of range index should be nil")
+	}
+}
@@ -1,32 +1,83 @@
 package minerconfig
 
 import (
+	"crypto/ed25519"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/KostasAronis/go-rfs/hashing"
+	"github.com/KostasAronis/go-rfs/rfslib"
 )
 
 //CommonMinerConfig struct describing the common configuration parameters shared by the miners
 type CommonMinerConfig struct {
-	//GenesisBlockHash The genesis (first) block MD5 hash for this blockchain
+	//GenesisBlockHash The genesis (first) block hash for this blockchain, computed with HashAlgorithm
 	GenesisBlockHash string
+	//HashAlgorithm The hash function of the block hashes: MD5 (default), SHA-256 or BLAKE2b
+	HashAlgorithm string
 	//MinedCoinsPerOpBlock The number of record coins mined for an op block
 	MinedCoinsPerOpBlock int
 	//MinedCoinsPerNoOpBlock The number of record coins mined for a no-op block
 	MinedCoinsPerNoOpBlock int
 	//NumCoinsPerFileCreate The number of record coins charged for creating a file
 	NumCoinsPerFileCreate int
+	//NumCoinsPerFileDelete The number of record coins charged for deleting a file
+	NumCoinsPerFileDelete int
+	//NumCoinsPerFileRename The number of record coins charged for renaming a file
+	NumCoinsPerFileRename int
 	//GenOpBlockTimeout Time in milliseconds, the minimum time between op block mining (see diagram above)
 	GenOpBlockTimeout int
-	//PowPerOpBlock The op block difficulty (proof of work setting: number of zeroes)
+	//PowPerOpBlock The op block difficulty (proof of work setting: number of leading zero bits of the block hash)
 	PowPerOpBlock int
-	//PowPerNoOpBlock The no-op block difficulty (proof of work setting: number of zeroes)
+	//PowPerNoOpBlock The no-op block difficulty (proof of work setting: number of leading zero bits of the block hash)
 	PowPerNoOpBlock int
+	//RetargetInterval The number of blocks between adjustments of the op and no-op block difficulties, 0 disables them
+	RetargetInterval int
+	//TargetBlockTime Time in milliseconds, the average time between blocks the difficulty adjustments aim for
+	TargetBlockTime int
+	//MaxTimeDrift Time in milliseconds a block timestamp may be ahead of the local clock of the miner, defaults to a minute
+	MaxTimeDrift int
+	//Consensus How the miners agree on the chain: PoW (proof of work, default) or PoA (round robin proof of authority of the miners with a PublicKey)
+	Consensus string
+	//AuthorityBlockTime Time in milliseconds, the time between blocks under PoA
+	AuthorityBlockTime int
+	//MaxRecordsPerFile The maximum number of records of a file, at most and by default 65535, the records a uint16 index can address
+	MaxRecordsPerFile int
 	//ConfirmsPerFileCreate The number of confirmations for a create file operation (the number of blocks that must follow the block containing a create file operation along longest chain before the CreateFile call can return successfully)
 	ConfirmsPerFileCreate int
 	//ConfirmsPerFileAppend The number of confirmations for an append operation (the number of blocks that must follow the block containing an append operation along longest chain before the AppendRec call can return successfully). Note that this append confirm number will always be set to be larger than the create confirm number (above)
 	ConfirmsPerFileAppend int
 }
 
+//The consensus names of CommonMinerConfig.Consensus
+const (
+	ProofOfWork      = "PoW"
+	ProofOfAuthority = "PoA"
+)
+
+//The file store names of Config.FileStore
+const (
+	MemoryFileStore = "memory"
+	DiskFileStore   = "disk"
+)
+
+//Algorithm returns the configured hash algorithm
+func (c *CommonMinerConfig) Algorithm() (hashing.Algorithm, error) {
+	return hashing.Parse(c.HashAlgorithm)
+}
+
+//MaxRecords returns the maximum number of records of a file, see MaxRecordsPerFile
+func (c *CommonMinerConfig) MaxRecords() int {
+	if c.MaxRecordsPerFile <= 0 || c.MaxRecordsPerFile > rfslib.MaxRecordsPerFile {
+		return rfslib.MaxRecordsPerFile
+	}
+	return c.MaxRecordsPerFile
+}
+
 //MinerConfig struct describing the configuration for individual mienrs
 type Config struct {
 	//MinerID The ID of this miner (max 16 characters).
@@ -39,13 +90,96 @@ type Config struct {
 	OutgoingMinersIP string
 	//IncomingClientsAddr The local IP:port where this miner should expect to receive connections from RFS clients (address it should listen on for connections from clients)
 	IncomingClientsAddr string
+	//PrivateKeyFile The file holding the hex encoded ed25519 private key seed of the miner, relative to the config file. Defaults to {MinerID}.key
+	PrivateKeyFile string
+	//PrivateKey the private key of the miner used to sign its blocks, see LoadOrCreateKey
+	PrivateKey ed25519.PrivateKey `json:"-"`
+	//BlockLogFile The append only log of the blocks accepted by the miner, replayed on startup, relative to the config file.
+	//Defaults to {MinerID}.blocks, see ResolvePaths. No blocks are persisted if empty
+	BlockLogFile string
+	//FileStore Where the records of the files are kept: memory (default) or disk, in record logs in FileStoreDir
+	FileStore string
+	//FileStoreDir The directory of the record logs of the disk file store, relative to the config file. Defaults to {MinerID}.files
+	FileStoreDir string
+	//MiningWorkers The number of goroutines searching nonces in parallel. Defaults to the number of CPUs
+	MiningWorkers int
 	//CommonMinerConfig struct describing the common configuration parameters shared by the miners
 	CommonMinerConfig CommonMinerConfig
 }
 
+//Workers returns the number of mining goroutines, see MiningWorkers
+func (c *Config) Workers() int {
+	if c.MiningWorkers <= 0 {
+		return runtime.NumCPU()
+	}
+	return c.MiningWorkers
+}
+
 type PeerMiner struct {
 	ID   string
 	Addr string
+	//PublicKey The hex encoded ed25519 public key of the peer miner. Blocks of the peer are only accepted if signed by this key
+	PublicKey string
+}
+
+//ResolvePaths sets BlockLogFile and FileStoreDir to their paths in dir, or to their defaults if they are not configured
+func (c *Config) ResolvePaths(dir string) {
+	if c.BlockLogFile == "" {
+		c.BlockLogFile = c.MinerID + ".blocks"
+	}
+	if c.FileStoreDir == "" {
+		c.FileStoreDir = c.MinerID + ".files"
+	}
+	if !filepath.IsAbs(c.BlockLogFile) {
+		c.BlockLogFile = filepath.Join(dir, c.BlockLogFile)
+	}
+	if !filepath.IsAbs(c.FileStoreDir) {
+		c.FileStoreDir = filepath.Join(dir, c.FileStoreDir)
+	}
+}
+
+//LoadOrCreateKey reads the private key of the miner from PrivateKeyFile in dir, see rfslib.LoadOrCreateKey.
+//If the file does not exist a new key is generated and stored in it
+func (c *Config) LoadOrCreateKey(dir string) error {
+	keyFile := c.PrivateKeyFile
+	if keyFile == "" {
+		keyFile = c.MinerID + ".key"
+	}
+	if !filepath.IsAbs(keyFile) {
+		keyFile = filepath.Join(dir, keyFile)
+	}
+	key, err := rfslib.LoadOrCreateKey(keyFile)
+	if err != nil {
+		return err
+	}
+	c.PrivateKey = key
+	return nil
+}
+
+//PublicKey returns the public key of the miner
+func (c *Config) PublicKey() ed25519.PublicKey {
+	return c.PrivateKey.Public().(ed25519.PublicKey)
+}
+
+//MinerKeys returns the public keys of this miner and its peers, indexed by miner id.
+//It returns nil if no peer has a PublicKey configured
+func (c *Config) MinerKeys() (map[string]ed25519.PublicKey, error) {
+	keys := map[string]ed25519.PublicKey{}
+	for _, peer := range c.PeerMiners {
+		if peer.PublicKey == "" {
+			continue
+		}
+		key, err := hex.DecodeString(peer.PublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key for peer miner %s", peer.ID)
+		}
+		keys[peer.ID] = ed25519.PublicKey(key)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	keys[c.MinerID] = c.PublicKey()
+	return keys, nil
 }
 
 //Load reads values from given filename
@@ -0,0 +1,364 @@
+package rfslib
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/tcp"
+	"github.com/KostasAronis/go-rfs/uuid"
+)
+
+//rfsClient implements RFS (and SharingRFS, AuditingRFS, NamespaceRFS) by forwarding every call to a single miner over tcp
+type rfsClient struct {
+	minerAddr string
+	tcpClient *tcp.Client
+	//key signs the CreateFile, AppendRec, DeleteFile and RenameFile ops of the client
+	key ed25519.PrivateKey
+	//readWaiters the ReadRec calls waiting for the miner to push their records by wait id, guarded by waitM
+	waitM       sync.Mutex
+	readWaiters map[string]chan *tcp.Msg
+}
+
+func newRfsClient(localAddr string, minerAddr string, key ed25519.PrivateKey) *rfsClient {
+	r := &rfsClient{
+		minerAddr: minerAddr,
+		key:       key,
+		tcpClient: &tcp.Client{
+			ID:         "rfs_" + strings.ReplaceAll(localAddr, ":", "_"),
+			Address:    localAddr,
+			LocalAddr:  localAddr,
+			TargetAddr: minerAddr,
+			Pushes:     make(chan *tcp.Msg, 10),
+		},
+		readWaiters: map[string]chan *tcp.Msg{},
+	}
+	go r.dispatchPushes()
+	return r
+}
+
+//dispatchPushes hands the records pushed by the miner to the ReadRec calls waiting for them.
+//A lost connection fails every waiting call, the miner drops their waits when the client disconnects
+func (r *rfsClient) dispatchPushes() {
+	for push := range r.tcpClient.Pushes {
+		if push.MSGType == tcp.Error {
+			r.waitM.Lock()
+			waiters := r.readWaiters
+			r.readWaiters = map[string]chan *tcp.Msg{}
+			r.waitM.Unlock()
+			for _, waiter := range waiters {
+				waiter <- push
+			}
+			continue
+		}
+		payload, ok := push.Payload.(map[string]interface{})
+		if push.MSGType != tcp.ReadRec || !ok {
+			continue
+		}
+		wait, _ := payload["Wait"].(string)
+		r.waitM.Lock()
+		waiter, ok := r.readWaiters[wait]
+		delete(r.readWaiters, wait)
+		r.waitM.Unlock()
+		if ok {
+			waiter <- push
+		}
+	}
+}
+
+//send sends the msg to the miner and converts any error (network or miner side) to the typed RFS errors
+func (r *rfsClient) send(msg *tcp.Msg, govecTag string) (interface{}, error) {
+	res, err := r.tcpClient.Send(msg, govecTag)
+	if err != nil {
+		return nil, DisconnectedError(r.minerAddr)
+	}
+	if res.MSGType == tcp.Error {
+		return nil, ErrorFromPayload(res.Payload)
+	}
+	return res.Payload, nil
+}
+
+func (r *rfsClient) ping() error {
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.Ping,
+	}
+	_, err := r.tcpClient.Send(&tcpMsg, "Ping")
+	return err
+}
+
+//signedPayload builds the payload of a CreateFile, AppendRec, DeleteFile or RenameFile msg signed by the client
+func (r *rfsClient) signedPayload(op *SignedOp) (map[string]interface{}, error) {
+	id, err := uuid.New()
+	if err != nil {
+		return nil, err
+	}
+	op.UUID = id
+	op.Timestamp = time.Now().UnixNano()
+	payload := map[string]interface{}{
+		"Filename":  op.Filename,
+		"UUID":      op.UUID,
+		"Timestamp": op.Timestamp,
+		"PublicKey": []byte(r.PublicKey()),
+		"Signature": op.Sign(r.key),
+	}
+	switch {
+	case op.Create:
+		payload["Writers"] = op.Writers
+	case op.Delete:
+	case op.NewFilename != "":
+		payload["NewFilename"] = op.NewFilename
+	default:
+		payload["Record"] = op.Record
+	}
+	return payload, nil
+}
+
+//PublicKey Returns the public key identifying this client as the owner or a writer of files
+func (r *rfsClient) PublicKey() ed25519.PublicKey {
+	return r.key.Public().(ed25519.PublicKey)
+}
+
+//CreateFile Creates a new empty RFS file with name fname.
+func (r *rfsClient) CreateFile(fname string) (err error) {
+	return r.CreateSharedFile(fname, nil)
+}
+
+//CreateSharedFile Creates a new empty RFS file with name fname that can be appended to
+// by its owner and the given writers.
+func (r *rfsClient) CreateSharedFile(fname string, writers []ed25519.PublicKey) (err error) {
+	err = CheckFilename(fname)
+	if err != nil {
+		return err
+	}
+	op := SignedOp{
+		Create:   true,
+		Filename: fname,
+		Writers:  [][]byte{},
+	}
+	for _, writer := range writers {
+		op.Writers = append(op.Writers, []byte(writer))
+	}
+	payload, err := r.signedPayload(&op)
+	if err != nil {
+		return err
+	}
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.CreateFile,
+		Payload: payload,
+	}
+	_, err = r.send(&tcpMsg, "Create: "+fname)
+	return err
+}
+
+//ListFiles Returns a slice of strings containing filenames of all the
+// existing files in RFS.
+func (r *rfsClient) ListFiles() (fnames []string, err error) {
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.ListFiles,
+	}
+	res, err := r.send(&tcpMsg, "ListFiles")
+	if err != nil {
+		return nil, err
+	}
+	fnames = []string{}
+	resArr, _ := res.([]interface{})
+	for _, v := range resArr {
+		fname, ok := v.(string)
+		if !ok {
+			return nil, errors.New("RFS: incorrect ListFiles response")
+		}
+		fnames = append(fnames, fname)
+	}
+	return fnames, nil
+}
+
+//TotalRecs Returns the total number of records in a file with filename
+// fname.
+func (r *rfsClient) TotalRecs(fname string) (numRecs uint16, err error) {
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.TotalRecs,
+		Payload: map[string]interface{}{
+			"Filename": fname,
+		},
+	}
+	res, err := r.send(&tcpMsg, "TotalRecs: "+fname)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := res.(float64)
+	if !ok || n < 0 || n > MaxRecordsPerFile {
+		return 0, errors.New("RFS: incorrect TotalRecs response")
+	}
+	return uint16(n), nil
+}
+
+//ReadRec Reads a record from file fname at position recordNum into
+// memory pointed to by record.
+//The miner pushes the record once it is confirmed, the waiter is registered before the request
+//since the push may arrive before the response
+func (r *rfsClient) ReadRec(fname string, recordNum uint16, record *Record) (err error) {
+	wait, err := uuid.New()
+	if err != nil {
+		return err
+	}
+	waiter := make(chan *tcp.Msg, 1)
+	r.waitM.Lock()
+	r.readWaiters[wait] = waiter
+	r.waitM.Unlock()
+	defer func() {
+		r.waitM.Lock()
+		delete(r.readWaiters, wait)
+		r.waitM.Unlock()
+	}()
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.ReadRec,
+		Payload: map[string]interface{}{
+			"Filename": fname,
+			"Record":   []uint16{recordNum},
+			"Wait":     wait,
+		},
+	}
+	_, err = r.send(&tcpMsg, "ReadRec: "+fname)
+	if err != nil {
+		return err
+	}
+	push := <-waiter
+	if push.MSGType == tcp.Error {
+		return DisconnectedError(r.minerAddr)
+	}
+	payload, _ := push.Payload.(map[string]interface{})
+	if errPayload, ok := payload["Error"]; ok {
+		return ErrorFromPayload(errPayload)
+	}
+	resArr, ok := payload["Records"].([]interface{})
+	if !ok || len(resArr) != 1 {
+		return errors.New("RFS: incorrect ReadRec response")
+	}
+	record.FromFloatArrayInterface(resArr[0])
+	return nil
+}
+
+//ReadRecAt Reads a record from file fname at position recordNum as it was
+// after the block at height into memory pointed to by record.
+func (r *rfsClient) ReadRecAt(fname string, recordNum uint16, height int, record *Record) (err error) {
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.ReadRec,
+		Payload: map[string]interface{}{
+			"Filename": fname,
+			"Record":   []uint16{recordNum},
+			"Height":   height,
+		},
+	}
+	res, err := r.send(&tcpMsg, "ReadRecAt: "+fname)
+	if err != nil {
+		return err
+	}
+	resArr, ok := res.([]interface{})
+	if !ok || len(resArr) != 1 {
+		return errors.New("RFS: incorrect ReadRec response")
+	}
+	record.FromFloatArrayInterface(resArr[0])
+	return nil
+}
+
+//AppendRec Appends a new record to a file with name fname with the
+// contents pointed to by record. Returns the position of the
+// record that was just appended as recordNum.
+func (r *rfsClient) AppendRec(fname string, record *Record) (recordNum uint16, err error) {
+	payload, err := r.signedPayload(&SignedOp{
+		Filename: fname,
+		Record:   record,
+	})
+	if err != nil {
+		return 0, err
+	}
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.AppendRec,
+		Payload: payload,
+	}
+	res, err := r.send(&tcpMsg, "AppendRec: "+fname)
+	if err != nil {
+		return 0, err
+	}
+	n, ok := res.(float64)
+	if !ok || n < 0 || n >= MaxRecordsPerFile {
+		return 0, errors.New("RFS: incorrect AppendRec response")
+	}
+	return uint16(n), nil
+}
+
+//DeleteFile Deletes the file with name fname and all of its records.
+func (r *rfsClient) DeleteFile(fname string) (err error) {
+	payload, err := r.signedPayload(&SignedOp{
+		Delete:   true,
+		Filename: fname,
+	})
+	if err != nil {
+		return err
+	}
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.DeleteFile,
+		Payload: payload,
+	}
+	_, err = r.send(&tcpMsg, "Delete: "+fname)
+	return err
+}
+
+//RenameFile Renames the file with name fname to newName, keeping its records.
+func (r *rfsClient) RenameFile(fname string, newName string) (err error) {
+	err = CheckFilename(newName)
+	if err != nil {
+		return err
+	}
+	if newName == "" {
+		return BadFilenameError(newName)
+	}
+	payload, err := r.signedPayload(&SignedOp{
+		Filename:    fname,
+		NewFilename: newName,
+	})
+	if err != nil {
+		return err
+	}
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.RenameFile,
+		Payload: payload,
+	}
+	_, err = r.send(&tcpMsg, "Rename: "+fname)
+	return err
+}
+
+//GetOpProof Returns the proof that the op of this client with the given uuid is included in a block
+// of the longest chain of the miner.
+func (r *rfsClient) GetOpProof(uuid string) (proof *OpProof, err error) {
+	tcpMsg := tcp.Msg{
+		MSGType: tcp.GetOpProof,
+		Payload: map[string]interface{}{
+			"UUID":      uuid,
+			"PublicKey": []byte(r.PublicKey()),
+		},
+	}
+	res, err := r.send(&tcpMsg, "GetOpProof: "+uuid)
+	if err != nil {
+		return nil, err
+	}
+	//the json encoded proof arrives as a base64 string like every []byte
+	encoded, ok := res.(string)
+	if !ok {
+		return nil, errors.New("RFS: incorrect GetOpProof response")
+	}
+	proofBytes, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("RFS: incorrect GetOpProof response")
+	}
+	proof = &OpProof{}
+	err = json.Unmarshal(proofBytes, proof)
+	if err != nil {
+		return nil, errors.New("RFS: incorrect GetOpProof response")
+	}
+	return proof, nil
+}
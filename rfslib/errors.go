@@ -0,0 +1,96 @@
+package rfslib
+
+import (
+	"errors"
+)
+
+//ErrorCode identifies the typed RFS errors in the Error responses of miners,
+//so that clients get them back without depending on their messages
+type ErrorCode int
+
+const (
+	//UnknownErrorCode any other error, clients only get its message
+	UnknownErrorCode ErrorCode = 0
+	//FileExistsErrorCode FileExistsError
+	FileExistsErrorCode ErrorCode = 1
+	//FileDoesNotExistErrorCode FileDoesNotExistError
+	FileDoesNotExistErrorCode ErrorCode = 2
+	//BadFilenameErrorCode BadFilenameError
+	BadFilenameErrorCode ErrorCode = 3
+	//DisconnectedErrorCode DisconnectedError
+	DisconnectedErrorCode ErrorCode = 4
+	//FileMaxLenReachedErrorCode FileMaxLenReachedError
+	FileMaxLenReachedErrorCode ErrorCode = 5
+	//NotAuthorizedErrorCode NotAuthorizedError
+	NotAuthorizedErrorCode ErrorCode = 6
+	//OpNotFoundErrorCode OpNotFoundError
+	OpNotFoundErrorCode ErrorCode = 7
+	//NotOwnerErrorCode NotOwnerError
+	NotOwnerErrorCode ErrorCode = 8
+)
+
+//errorConstructors the constructors of the typed RFS errors by code
+var errorConstructors = map[ErrorCode]func(string) error{
+	FileExistsErrorCode:        func(s string) error { return FileExistsError(s) },
+	FileDoesNotExistErrorCode:  func(s string) error { return FileDoesNotExistError(s) },
+	BadFilenameErrorCode:       func(s string) error { return BadFilenameError(s) },
+	DisconnectedErrorCode:      func(s string) error { return DisconnectedError(s) },
+	FileMaxLenReachedErrorCode: func(s string) error { return FileMaxLenReachedError(s) },
+	NotAuthorizedErrorCode:     func(s string) error { return NotAuthorizedError(s) },
+	OpNotFoundErrorCode:        func(s string) error { return OpNotFoundError(s) },
+	NotOwnerErrorCode:          func(s string) error { return NotOwnerError(s) },
+}
+
+//errorCode returns the code of a typed RFS error and its argument
+func errorCode(err error) (ErrorCode, string) {
+	switch e := err.(type) {
+	case FileExistsError:
+		return FileExistsErrorCode, string(e)
+	case FileDoesNotExistError:
+		return FileDoesNotExistErrorCode, string(e)
+	case BadFilenameError:
+		return BadFilenameErrorCode, string(e)
+	case DisconnectedError:
+		return DisconnectedErrorCode, string(e)
+	case FileMaxLenReachedError:
+		return FileMaxLenReachedErrorCode, string(e)
+	case NotAuthorizedError:
+		return NotAuthorizedErrorCode, string(e)
+	case OpNotFoundError:
+		return OpNotFoundErrorCode, string(e)
+	case NotOwnerError:
+		return NotOwnerErrorCode, string(e)
+	default:
+		return UnknownErrorCode, ""
+	}
+}
+
+//ErrorPayload returns the payload of the Error response of a miner to a client for err, see ErrorFromPayload
+func ErrorPayload(err error) map[string]interface{} {
+	code, arg := errorCode(err)
+	return map[string]interface{}{
+		"Code": int(code),
+		"Arg":  arg,
+		"Msg":  err.Error(),
+	}
+}
+
+//ErrorFromPayload returns the error in the payload of an Error response of a miner: the typed RFS error
+//for the payloads of ErrorPayload with a known code, a plain error with the message otherwise
+func ErrorFromPayload(payload interface{}) error {
+	switch p := payload.(type) {
+	case string:
+		return errors.New(p)
+	case map[string]interface{}:
+		msg, _ := p["Msg"].(string)
+		arg, _ := p["Arg"].(string)
+		//numbers arrive as float64 in the json encoded responses
+		code, _ := p["Code"].(float64)
+		if newError, ok := errorConstructors[ErrorCode(code)]; ok {
+			return newError(arg)
+		}
+		return errors.New(msg)
+	default:
+		return errors.New("RFS: incorrect error response")
+	}
+}
@@ -0,0 +1,56 @@
+package rfslib_test
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+//roundTrip returns the payload as a client decodes it from the json encoded response of a miner
+func roundTrip(t *testing.T, payload interface{}) interface{} {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	err = json.Unmarshal(data, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestErrorPayload(t *testing.T) {
+	tests := []error{
+		rfslib.FileExistsError("f1"),
+		rfslib.FileDoesNotExistError("f1"),
+		rfslib.BadFilenameError("a [b]"),
+		rfslib.DisconnectedError("127.0.0.1:8080"),
+		rfslib.FileMaxLenReachedError("f1"),
+		rfslib.NotAuthorizedError("f1"),
+		rfslib.OpNotFoundError("uuid"),
+		rfslib.NotOwnerError(""),
+	}
+	for _, sent := range tests {
+		received := rfslib.ErrorFromPayload(roundTrip(t, rfslib.ErrorPayload(sent)))
+		if !reflect.DeepEqual(received, sent) {
+			t.Errorf("%T %q was received as %T %q", sent, sent.Error(), received, received.Error())
+		}
+	}
+	//errors without a code, or with a message that looks like a typed one, keep only their message
+	for _, sent := range []error{
+		errors.New("insufficient coins"),
+		errors.New(rfslib.FileExistsError("f1").Error()),
+	} {
+		received := rfslib.ErrorFromPayload(roundTrip(t, rfslib.ErrorPayload(sent)))
+		if _, typed := received.(rfslib.FileExistsError); typed || received.Error() != sent.Error() {
+			t.Errorf("%q was received as %T %q", sent.Error(), received, received.Error())
+		}
+	}
+	if err := rfslib.ErrorFromPayload(roundTrip(t, "Incorrect payload")); err.Error() != "Incorrect payload" {
+		t.Errorf("string payloads should be received as their message, got %q", err.Error())
+	}
+}
@@ -0,0 +1,15 @@
+package rfslib
+
+//MaxFilenameLen the maximum length of a filename in bytes
+const MaxFilenameLen = 64
+
+//MaxRecordsPerFile the maximum number of records of a file, the number of records a uint16 record index can address
+const MaxRecordsPerFile = 1<<16 - 1
+
+//CheckFilename returns a BadFilenameError if the filename is longer than MaxFilenameLen bytes
+func CheckFilename(fname string) error {
+	if len(fname) > MaxFilenameLen {
+		return BadFilenameError(fname)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package rfslib
+
+import (
+	"fmt"
+)
+
+//NotOwnerError Contains filename. Returned when deleting or renaming a file without being its owner
+type NotOwnerError string
+
+func (e NotOwnerError) Error() string {
+	return fmt.Sprintf("RFS: Only the owner can delete or rename file [%s]", string(e))
+}
+
+//NamespaceRFS extends RFS with the removal and renaming of files.
+//The RFS returned by Initialize and InitializeWithKey implements it.
+type NamespaceRFS interface {
+	RFS
+
+	// Deletes the file with name fname and all of its records. Only the
+	// owner of the file (the client that created it) can delete it.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - FileDoesNotExistError
+	// - NotOwnerError
+	DeleteFile(fname string) (err error)
+
+	// Renames the file with name fname to newName, keeping its records,
+	// owner and writers. Only the owner of the file can rename it.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - FileDoesNotExistError
+	// - FileExistsError
+	// - BadFilenameError
+	// - NotOwnerError
+	RenameFile(fname string, newName string) (err error)
+
+	// Reads the record at position recordNum of file fname as it was
+	// after the block at the given height of the longest chain, also if
+	// the file has since been deleted or renamed. The height of the block
+	// of an op is in its OpProof. Unlike ReadRec it does not block, a
+	// record that did not exist at that height is an error.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - FileDoesNotExistError
+	ReadRecAt(fname string, recordNum uint16, height int, record *Record) (err error)
+}
@@ -0,0 +1,73 @@
+package rfslib
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//NotAuthorizedError Contains filename. Returned when appending to a file without being its owner or one of its writers
+type NotAuthorizedError string
+
+func (e NotAuthorizedError) Error() string {
+	return fmt.Sprintf("RFS: Not authorized to append to file [%s]", string(e))
+}
+
+//SharingRFS extends RFS with the identity of the client and files shared with other clients.
+//The RFS returned by Initialize and InitializeWithKey implements it.
+type SharingRFS interface {
+	RFS
+
+	// Creates a new empty RFS file with name fname that can be appended to
+	// by its owner (this client) and the clients with the given public keys.
+	//
+	// Can return the same errors as CreateFile
+	CreateSharedFile(fname string, writers []ed25519.PublicKey) (err error)
+
+	// Returns the public key identifying this client as the owner or a writer of files
+	PublicKey() ed25519.PublicKey
+}
+
+//InitializeWithKey is the same as Initialize but signs the ops of the client with the given key,
+//so that the client keeps its files across sessions. Initialize uses a new key on every call
+func InitializeWithKey(localAddr string, minerAddr string, key ed25519.PrivateKey) (rfs RFS, err error) {
+	c := newRfsClient(localAddr, minerAddr, key)
+	err = c.ping()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//LoadOrCreateKey reads a hex encoded ed25519 private key seed from keyFile.
+//If the file does not exist a new key is generated and stored in it, readable only by its owner.
+//Miners and clients share this helper so that their key files have the same format
+func LoadOrCreateKey(keyFile string) (ed25519.PrivateKey, error) {
+	data, err := ioutil.ReadFile(keyFile)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		err = ioutil.WriteFile(keyFile, []byte(hex.EncodeToString(key.Seed())+"\n"), 0600)
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %s", keyFile, err.Error())
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid key file %s: expected %d bytes got %d", keyFile, ed25519.SeedSize, len(seed))
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
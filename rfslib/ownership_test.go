@@ -0,0 +1,44 @@
+package rfslib_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/KostasAronis/go-rfs/rfslib"
+)
+
+func TestLoadOrCreateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "client.key")
+	key, err := rfslib.LoadOrCreateKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("The key file should only be readable by its owner, got %v", info.Mode().Perm())
+	}
+	loaded, err := rfslib.LoadOrCreateKey(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(loaded) {
+		t.Error("The stored key should be loaded again")
+	}
+	err = ioutil.WriteFile(keyFile, []byte("abcd\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rfslib.LoadOrCreateKey(keyFile); err == nil {
+		t.Error("A key file with a short seed should be rejected")
+	}
+}
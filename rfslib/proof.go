@@ -0,0 +1,142 @@
+package rfslib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/KostasAronis/go-rfs/hashing"
+	"github.com/KostasAronis/go-rfs/merkle"
+)
+
+//OpNotFoundError Contains the op uuid. Returned when an op is not included in the longest chain of the miner
+type OpNotFoundError string
+
+func (e OpNotFoundError) Error() string {
+	return fmt.Sprintf("RFS: Op [%s] is not on the longest chain", string(e))
+}
+
+//AuditingRFS extends RFS with proofs that ops are included in blocks.
+//The RFS returned by Initialize and InitializeWithKey implements it.
+type AuditingRFS interface {
+	RFS
+
+	// Returns the proof that the op of this client with the given uuid is included
+	// in a block of the longest chain of the miner. The proof must be checked with VerifyOpProof
+	// against a block hash the caller trusts.
+	//
+	// Can return the following errors:
+	// - DisconnectedError
+	// - OpNotFoundError
+	GetOpProof(uuid string) (proof *OpProof, err error)
+}
+
+//BlockHeader the fields of a block covered by its hash
+type BlockHeader struct {
+	Version uint8
+	//HashAlgorithm the hash function of the block hash
+	HashAlgorithm hashing.Algorithm
+	PrevHash      string
+	MinerID       string
+	PublicKey     []byte
+	IsOp          bool
+	//OpsRoot the merkle root of the ops of the block, see BlockOp
+	OpsRoot []byte
+	//Timestamp the unix time in nanoseconds at which the miner started mining the block
+	Timestamp int64
+	Nonce     uint32
+}
+
+//Bytes returns the canonical binary encoding of the header, the only input of the block hash:
+//
+//	version | hash algorithm | prev hash | miner id | public key | is op | ops root | timestamp | nonce
+//
+//Strings and byte slices are prefixed by their length and integers are big endian.
+//The nonce comes last so that miners can encode the header once and only replace the nonce.
+func (h *BlockHeader) Bytes() []byte {
+	buf := bytes.Buffer{}
+	buf.WriteByte(h.Version)
+	buf.WriteByte(byte(h.HashAlgorithm))
+	writeBytes(&buf, []byte(h.PrevHash))
+	writeBytes(&buf, []byte(h.MinerID))
+	writeBytes(&buf, h.PublicKey)
+	if h.IsOp {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.Write(h.OpsRoot)
+	binary.Write(&buf, binary.BigEndian, h.Timestamp)
+	binary.Write(&buf, binary.BigEndian, h.Nonce)
+	return buf.Bytes()
+}
+
+//Hash returns the hex encoded hash of the encoded header with its HashAlgorithm, the hash of its block
+func (h *BlockHeader) Hash() string {
+	return hex.EncodeToString(h.HashAlgorithm.Sum(h.Bytes()))
+}
+
+//BlockOp an op as included in a block, a leaf of the ops merkle tree of the block
+type BlockOp struct {
+	SignedOp
+	//MinerID the miner that received the op from its client
+	MinerID         string
+	ClientKey       []byte
+	ClientSignature []byte
+}
+
+//Bytes returns the canonical encoding of the op, the merkle leaf
+func (o *BlockOp) Bytes() []byte {
+	buf := bytes.Buffer{}
+	writeBytes(&buf, o.SignedOp.Bytes())
+	writeBytes(&buf, []byte(o.MinerID))
+	writeBytes(&buf, o.ClientKey)
+	writeBytes(&buf, o.ClientSignature)
+	return buf.Bytes()
+}
+
+//OpProof proves that an op is included in the block with BlockHash
+type OpProof struct {
+	BlockHash string
+	Header    BlockHeader
+	Op        BlockOp
+	//Path the merkle path from the op to the OpsRoot of the header
+	Path []merkle.Step
+	//Height the height of the block on the longest chain of the miner, for NamespaceRFS.ReadRecAt.
+	//It is not covered by the proof
+	Height int
+}
+
+//CommittedAt returns the time the block including the op was mined, as claimed by its miner.
+//Miners only accept blocks later than the median of their ancestors and not too far ahead of their clock
+func (p *OpProof) CommittedAt() time.Time {
+	return time.Unix(0, p.Header.Timestamp).UTC()
+}
+
+//VerifyOpProof checks that the op of the proof is included in the block with the trusted hash blockHash
+//and that it was signed by its client. The caller should also check the fields of proof.Op it cares about,
+//e.g. that proof.Op.Record is the record it appended
+func VerifyOpProof(proof *OpProof, blockHash string) error {
+	if proof.BlockHash != blockHash {
+		return fmt.Errorf("proof is for block %s instead of %s", proof.BlockHash, blockHash)
+	}
+	if err := proof.Header.HashAlgorithm.Check(); err != nil {
+		return err
+	}
+	if proof.Header.Hash() != blockHash {
+		return errors.New("block header does not match the block hash")
+	}
+	if len(proof.Header.OpsRoot) != merkle.Size {
+		return errors.New("block header has no valid ops root")
+	}
+	if !merkle.Verify(proof.Op.Bytes(), proof.Path, proof.Header.OpsRoot) {
+		return fmt.Errorf("op %s is not included in block %s", proof.Op.UUID, blockHash)
+	}
+	if !proof.Op.Verify(proof.Op.ClientKey, proof.Op.ClientSignature) {
+		return fmt.Errorf("invalid client signature for op %s", proof.Op.UUID)
+	}
+	return nil
+}
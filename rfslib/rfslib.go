@@ -10,7 +10,11 @@ it.
 
 */
 
-import "fmt"
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+)
 
 // A Record is the unit of file access (reading/appending) in RFS.
 type Record [512]byte
@@ -124,7 +128,9 @@ type RFS interface {
 // succeeds. This call can return the following errors:
 // - Networking errors related to localAddr or minerAddr
 func Initialize(localAddr string, minerAddr string) (rfs RFS, err error) {
-	// TODO
-	// For now return a DisconnectedError
-	return nil, DisconnectedError(minerAddr)
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return InitializeWithKey(localAddr, minerAddr, key)
 }
@@ -0,0 +1,77 @@
+package rfslib
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+//SignedOp the fields of a CreateFile, AppendRec, DeleteFile or RenameFile op covered by the signature of the client that issued it
+type SignedOp struct {
+	//Create true for CreateFile ops
+	Create bool
+	//Delete true for DeleteFile ops
+	Delete bool
+	//NewFilename the new name of the file for RenameFile ops, empty for every other op.
+	//An op that is neither a CreateFile, a DeleteFile nor a RenameFile op is an AppendRec op
+	NewFilename string
+	Filename    string
+	//Record the appended record, only its hash is signed (nil for CreateFile ops)
+	Record *Record
+	UUID   string
+	//Timestamp the unix time of the op in nanoseconds
+	Timestamp int64
+	//Writers the public keys allowed to append to a created file besides its owner
+	Writers [][]byte
+}
+
+//Bytes returns the canonical encoding of the op that gets signed
+func (o *SignedOp) Bytes() []byte {
+	buf := bytes.Buffer{}
+	switch {
+	case o.Create:
+		buf.WriteByte(1)
+	case o.Delete:
+		buf.WriteByte(3)
+	case o.NewFilename != "":
+		buf.WriteByte(4)
+	default:
+		buf.WriteByte(2)
+	}
+	writeBytes(&buf, []byte(o.Filename))
+	if o.NewFilename != "" {
+		writeBytes(&buf, []byte(o.NewFilename))
+	}
+	recordHash := [sha256.Size]byte{}
+	if o.Record != nil {
+		recordHash = sha256.Sum256(o.Record[:])
+	}
+	buf.Write(recordHash[:])
+	writeBytes(&buf, []byte(o.UUID))
+	binary.Write(&buf, binary.BigEndian, o.Timestamp)
+	binary.Write(&buf, binary.BigEndian, uint32(len(o.Writers)))
+	for _, writer := range o.Writers {
+		writeBytes(&buf, writer)
+	}
+	return buf.Bytes()
+}
+
+//Sign returns the signature of the op by the given key
+func (o *SignedOp) Sign(key ed25519.PrivateKey) []byte {
+	return ed25519.Sign(key, o.Bytes())
+}
+
+//Verify checks the signature of the op against the given public key
+func (o *SignedOp) Verify(publicKey []byte, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(publicKey), o.Bytes(), signature)
+}
+
+//writeBytes writes b prefixed by its length so that consecutive fields can not be confused
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(b)))
+	buf.Write(b)
+}
@@ -70,6 +70,16 @@ func DecodeToBlock(s []byte) (*blockchain.Block, error) {
 	return &p, nil
 }
 
+func DecodeToOp(s []byte) (*blockchain.OpRecord, error) {
+	p := blockchain.OpRecord{}
+	dec := gob.NewDecoder(bytes.NewReader(s))
+	err := dec.Decode(&p)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
 func WriteToFile(s []byte, file string) error {
 	f, err := os.Create(file)
 	if err != nil {
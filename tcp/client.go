@@ -2,84 +2,338 @@ package tcp
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/DistributedClocks/GoVector/govec"
 )
 
-//Client describes a simple tcp client
+//dialAttempts how many times a Send tries to (re)connect to the target before giving up
+const dialAttempts = 3
+
+//minBackoff and maxBackoff bound the wait between consecutive reconnection attempts
+const minBackoff = 100 * time.Millisecond
+const maxBackoff = 5 * time.Second
+
+//ErrConnectionLost returned for requests that were in flight when the connection to the target dropped
+var ErrConnectionLost = errors.New("tcp: connection lost")
+
+//ErrClientClosed returned when sending through a closed client
+var ErrClientClosed = errors.New("tcp: client closed")
+
+//ErrTimeout returned for requests that were not answered within the Timeout of the client
+var ErrTimeout = errors.New("tcp: request timed out")
+
+//Client describes a simple tcp client. It keeps a single long lived connection to the target
+//which is shared by all concurrent requests and reconnects when the connection drops
 type Client struct {
-	ID          string
-	Address     string
+	ID      string
+	Address string
+	//LocalAddr the local IP:port used when dialing the target (optional)
+	LocalAddr   string
 	TargetAddr  string
 	TargetID    string
 	GovecLogger *govec.GoLog
-	msgQueue    chan *queuedRequest
+	//Timeout bounds the time a Send waits to write its request and receive the response (optional)
+	Timeout time.Duration
+	//Pushes receives the messages initiated by the server (optional). When set the client registers with the server
+	//on every connection and reconnects in the background, see Listen. An Error msg is received when the connection
+	//drops, since the pushes sent until the client reconnects are lost
+	Pushes chan *Msg
+
+	m       sync.Mutex
+	writeM  sync.Mutex
+	conn    net.Conn
+	dialing *dialCall
+	nextID  uint64
+	pending map[uint64]chan *queuedResponse
+	closed  bool
+}
+
+//dialCall a dial in progress, shared by all the Sends that need a connection until it ends
+type dialCall struct {
+	done chan struct{}
+	conn net.Conn
+	err  error
 }
 
-type queuedRequest struct {
-	req      *Msg
-	resChan  chan *Msg
-	govecTag string
+type queuedResponse struct {
+	res *Msg
+	err error
 }
 
-//Send Sends tcp message to server
-func (c *Client) Send(msg *Msg, govecTag string) *Msg {
-	if c.msgQueue == nil {
-		c.msgQueue = make(chan *queuedRequest, 20)
-		go c.send()
+//Send Sends tcp message to server. The returned error is only set on network failures and timeouts,
+//errors reported by the server are returned as a Msg of MSGType Error.
+//Send is safe for concurrent use, msg is not modified
+func (c *Client) Send(msg *Msg, govecTag string) (*Msg, error) {
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	req := *msg
+	if req.ClientID == "" {
+		req.ClientID = c.ID
 	}
-	resChan := make(chan *Msg)
-	c.msgQueue <- &queuedRequest{
-		req:      msg,
-		resChan:  resChan,
-		govecTag: govecTag,
+	if govecTag == "" {
+		govecTag = "SendingMessage"
+	}
+	resChan := make(chan *queuedResponse, 1)
+	c.m.Lock()
+	if c.conn != conn {
+		c.m.Unlock()
+		return nil, ErrConnectionLost
+	}
+	c.nextID++
+	req.ID = c.nextID
+	c.pending[req.ID] = resChan
+	c.m.Unlock()
+	log.Printf("sending tcp to %s", c.TargetAddr)
+	var timeout <-chan time.Time
+	if c.Timeout > 0 {
+		timer := time.NewTimer(c.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	c.writeM.Lock()
+	if c.Timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	}
+	vectorClockMessage := c.GovecLogger.PrepareSend(govecTag, &req, govec.GetDefaultLogOptions())
+	err = writeFrame(conn, vectorClockMessage)
+	c.writeM.Unlock()
+	if err != nil {
+		log.Println("TCP WRITE ERR: " + err.Error())
+		c.dropConn(conn, err)
+	}
+	select {
+	case res := <-resChan:
+		return res.res, res.err
+	case <-timeout:
+		//ids are never reused, so the request can be dropped from whichever connection is current
+		c.m.Lock()
+		delete(c.pending, req.ID)
+		c.m.Unlock()
+		return nil, ErrTimeout
 	}
-	res := <-resChan
-	return res
 }
-func (c *Client) send() {
-	for {
-		queuedRequest := <-c.msgQueue
-		msg := queuedRequest.req
-		log.Printf("sending tcp to %s", c.TargetAddr)
-		if msg.ClientID == "" {
-			msg.ClientID = c.ID
+
+//Listen connects to the target so that the server can push to the client before it sends any request.
+//If the target cannot be reached the client keeps reconnecting in the background
+func (c *Client) Listen() error {
+	_, err := c.connect()
+	if err != nil && err != ErrClientClosed && c.Pushes != nil {
+		go c.reconnect()
+	}
+	return err
+}
+
+//Close closes the connection to the target and fails all requests in flight
+func (c *Client) Close() error {
+	c.m.Lock()
+	c.closed = true
+	conn := c.conn
+	c.m.Unlock()
+	if conn == nil {
+		return nil
+	}
+	c.dropConn(conn, ErrClientClosed)
+	return nil
+}
+
+//connect returns the current connection to the target, dialing a new one with backoff if there is none.
+//Only one dial is in progress at a time, the Sends arriving meanwhile wait for its result without holding c.m
+func (c *Client) connect() (net.Conn, error) {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return nil, ErrClientClosed
+	}
+	if c.conn != nil {
+		conn := c.conn
+		c.m.Unlock()
+		return conn, nil
+	}
+	if call := c.dialing; call != nil {
+		c.m.Unlock()
+		<-call.done
+		return call.conn, call.err
+	}
+	if c.GovecLogger == nil {
+		goVecConfig := govec.GetDefaultConfig()
+		goVecConfig.UseTimestamps = true
+		goVecConfig.AppendLog = true
+		c.GovecLogger = govec.InitGoVector(c.ID, c.ID+"GoVector.log", goVecConfig)
+	}
+	call := &dialCall{done: make(chan struct{})}
+	c.dialing = call
+	c.m.Unlock()
+	defer close(call.done)
+	call.conn, call.err = c.dialWithBackoff()
+	c.m.Lock()
+	c.dialing = nil
+	if call.err == nil && c.closed {
+		call.conn.Close()
+		call.conn, call.err = nil, ErrClientClosed
+	}
+	if call.err == nil {
+		c.conn = call.conn
+		c.pending = map[uint64]chan *queuedResponse{}
+		go c.readLoop(call.conn)
+	}
+	c.m.Unlock()
+	if call.err == nil && c.Pushes != nil {
+		c.hello(call.conn)
+	}
+	return call.conn, call.err
+}
+
+//dialWithBackoff dials the target up to dialAttempts times, waiting longer after every failed attempt
+func (c *Client) dialWithBackoff() (net.Conn, error) {
+	var err error
+	backoff := minBackoff
+	for i := 0; i < dialAttempts; i++ {
+		if i > 0 {
+			time.Sleep(backoff)
+			backoff = nextBackoff(backoff)
 		}
-		if c.GovecLogger == nil {
-			goVecConfig := govec.GetDefaultConfig()
-			goVecConfig.UseTimestamps = true
-			goVecConfig.AppendLog = true
-			c.GovecLogger = govec.InitGoVector(c.ID, c.ID+"GoVector.log", goVecConfig)
+		var conn net.Conn
+		conn, err = c.dial()
+		if err == nil {
+			return conn, nil
 		}
-		if queuedRequest.govecTag == "" {
-			queuedRequest.govecTag = "SendingMessage"
+	}
+	return nil, err
+}
+
+//hello registers the id of the client with the server on a new connection, so that the server can push to it.
+//The response is not waited for, the server registers the client before it reads the next request
+func (c *Client) hello(conn net.Conn) {
+	c.m.Lock()
+	c.nextID++
+	req := Msg{
+		ID:       c.nextID,
+		ClientID: c.ID,
+		MSGType:  Hello,
+	}
+	c.m.Unlock()
+	c.writeM.Lock()
+	if c.Timeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(c.Timeout))
+	}
+	vectorClockMessage := c.GovecLogger.PrepareSend("Hello", &req, govec.GetDefaultLogOptions())
+	err := writeFrame(conn, vectorClockMessage)
+	c.writeM.Unlock()
+	if err != nil {
+		log.Println("TCP WRITE ERR: " + err.Error())
+		c.dropConn(conn, err)
+	}
+}
+
+func (c *Client) dial() (net.Conn, error) {
+	d := net.Dialer{Timeout: 2 * time.Second}
+	if c.LocalAddr != "" {
+		localAddr, err := net.ResolveTCPAddr("tcp", c.LocalAddr)
+		if err != nil {
+			log.Printf("TCP RESOLVE ERR: %s", err.Error())
+			return nil, err
 		}
-		vectorClockMessage := c.GovecLogger.PrepareSend(queuedRequest.govecTag, msg, govec.GetDefaultLogOptions())
-		d := net.Dialer{Timeout: 2 * time.Second}
-		conn, err := d.Dial("tcp", c.TargetAddr)
+		d.LocalAddr = localAddr
+	}
+	conn, err := d.Dial("tcp", c.TargetAddr)
+	if err != nil {
+		log.Printf("TCP DIAL ERR: %s", err.Error())
+		return nil, err
+	}
+	return conn, nil
+}
+
+//readLoop dispatches the responses read from conn to the waiting requests and the pushes to c.Pushes
+func (c *Client) readLoop(conn net.Conn) {
+	for {
+		resBytes, err := readFrame(conn)
 		if err != nil {
-			log.Printf("TCP DIAL ERR: %s", err.Error())
-			queuedRequest.resChan <- &Msg{
-				ClientID: c.ID,
-				MSGType:  Error,
-				Payload:  err.Error(),
+			log.Println("TCP READ ERR: " + err.Error())
+			c.dropConn(conn, ErrConnectionLost)
+			c.m.Lock()
+			closed := c.closed
+			c.m.Unlock()
+			if c.Pushes != nil && !closed {
+				c.Pushes <- &Msg{
+					MSGType: Error,
+					Payload: ErrConnectionLost.Error(),
+				}
 			}
-			continue
+			return
 		}
-		//log.Printf("send: %+v\n", vectorClockMessage)
-		n, err := conn.Write(vectorClockMessage)
+		res := Msg{}
+		err = json.Unmarshal(resBytes, &res)
 		if err != nil {
-			log.Println("TCP WRITE ERR: " + err.Error())
+			log.Println("TCP DECODE ERR: " + err.Error())
 			continue
 		}
-		resBytes := make([]byte, 4096)
-		n, err = conn.Read(resBytes)
-		res := Msg{}
-		json.Unmarshal(resBytes[0:n], &res)
-		//log.Printf("recv: %+v\n", res)
-		queuedRequest.resChan <- &res
+		if res.ID == 0 && res.MSGType == Error {
+			//the server closes the connection after it, failing the pending requests
+			log.Printf("TCP ERR: %s rejected a request: %v", c.TargetAddr, res.Payload)
+			continue
+		}
+		if res.ID == 0 {
+			if c.Pushes != nil {
+				c.Pushes <- &res
+			}
+			continue
+		}
+		c.m.Lock()
+		resChan, ok := c.pending[res.ID]
+		delete(c.pending, res.ID)
+		c.m.Unlock()
+		if ok {
+			resChan <- &queuedResponse{res: &res}
+		}
+	}
+}
+
+//dropConn closes conn and fails all of its pending requests with err. The next Send reconnects and,
+//if the client listens for pushes, the client starts reconnecting in the background
+func (c *Client) dropConn(conn net.Conn, err error) {
+	c.m.Lock()
+	if c.conn != conn {
+		c.m.Unlock()
+		return
+	}
+	c.conn = nil
+	pending := c.pending
+	c.pending = nil
+	reconnect := c.Pushes != nil && !c.closed
+	c.m.Unlock()
+	conn.Close()
+	for _, resChan := range pending {
+		resChan <- &queuedResponse{err: err}
+	}
+	if reconnect {
+		go c.reconnect()
+	}
+}
+
+//reconnect keeps trying to connect to the target with an increasing backoff until it succeeds or the client is closed
+func (c *Client) reconnect() {
+	backoff := minBackoff
+	for {
+		time.Sleep(backoff)
+		_, err := c.connect()
+		if err == nil || err == ErrClientClosed {
+			return
+		}
+		backoff = nextBackoff(backoff)
+	}
+}
+
+func nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxBackoff {
+		return maxBackoff
 	}
+	return backoff
 }
@@ -0,0 +1,65 @@
+package tcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//MaxFrameSize the maximum payload size (in bytes) of a single frame on the wire
+const MaxFrameSize = 16 * 1024 * 1024
+
+//frameHeaderSize the size of the length header preceding every frame payload
+const frameHeaderSize = 4
+
+//ErrEmptyFrame returned when a frame with no payload is read or written
+var ErrEmptyFrame = errors.New("tcp: empty frame")
+
+//FrameTooLargeError returned when a frame exceeds MaxFrameSize
+type FrameTooLargeError int
+
+func (e FrameTooLargeError) Error() string {
+	return fmt.Sprintf("tcp: frame of %d bytes exceeds the maximum of %d bytes", int(e), MaxFrameSize)
+}
+
+//writeFrame writes the payload to w prefixed by its length as a 4 byte big endian unsigned integer
+func writeFrame(w io.Writer, payload []byte) error {
+	if len(payload) == 0 {
+		return ErrEmptyFrame
+	}
+	if len(payload) > MaxFrameSize {
+		return FrameTooLargeError(len(payload))
+	}
+	frame := make([]byte, frameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[frameHeaderSize:], payload)
+	_, err := w.Write(frame)
+	return err
+}
+
+//readFrame reads a single length prefixed frame from r and returns its payload.
+//The payload is rejected before being read if the header announces more than MaxFrameSize bytes
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size == 0 {
+		return nil, ErrEmptyFrame
+	}
+	if size > MaxFrameSize {
+		return nil, FrameTooLargeError(size)
+	}
+	payload := make([]byte, size)
+	_, err = io.ReadFull(r, payload)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
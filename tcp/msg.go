@@ -1,6 +1,9 @@
 package tcp
 
 type Msg struct {
+	//ID identifies a request and its response on a shared connection. Messages pushed by the server have ID 0.
+	//The server answers a malformed request with an Error msg with ID 0 and closes the connection, pushes are never Error msgs
+	ID       uint64
 	ClientID string
 	MSGType  MSGType
 	Payload  interface{}
@@ -19,7 +19,23 @@ const (
 	//Block message send by peer miners
 	Block MSGType = 6
 	//StoreAndStop stops the server and stores the blockchain to a file
-	StoreAndStop = 7
+	StoreAndStop MSGType = 7
+	//Ping message send by client to check connectivity with the miner
+	Ping MSGType = 8
+	//GetTip message send by peer miners to get the hash and height of the tip of the longest chain
+	GetTip MSGType = 9
+	//GetHeaders message send by peer miners to get the hashes of the longest chain following a given hash
+	GetHeaders MSGType = 10
+	//GetBlocks message send by peer miners to get the blocks with the given hashes
+	GetBlocks MSGType = 11
+	//GetOpProof message send by client to get the proof that an op is included in a block of the longest chain
+	GetOpProof MSGType = 12
+	//DeleteFile message send by client and peer miners
+	DeleteFile MSGType = 13
+	//RenameFile message send by client and peer miners
+	RenameFile MSGType = 14
+	//Hello message send by clients listening for pushes to register their id with the server, answered by the server itself
+	Hello MSGType = 15
 )
 
 func (m MSGType) String() string {
@@ -38,6 +54,24 @@ func (m MSGType) String() string {
 		return "ReadRec"
 	case Block:
 		return "Block"
+	case StoreAndStop:
+		return "StoreAndStop"
+	case Ping:
+		return "Ping"
+	case GetTip:
+		return "GetTip"
+	case GetHeaders:
+		return "GetHeaders"
+	case GetBlocks:
+		return "GetBlocks"
+	case GetOpProof:
+		return "GetOpProof"
+	case DeleteFile:
+		return "DeleteFile"
+	case RenameFile:
+		return "RenameFile"
+	case Hello:
+		return "Hello"
 	default:
 		return "UnknownMsg"
 	}
@@ -2,22 +2,46 @@ package tcp
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net"
+	"sync"
 
 	"github.com/DistributedClocks/GoVector/govec"
 )
 
-//Server describes a server listening for tcp messages from tcp clients
+//ErrUnknownClient returned when pushing to a client that has no open connection to the server
+var ErrUnknownClient = errors.New("tcp: no connection from client")
+
+//ErrMalformedRequest the error sent before closing a session that sent a request which could not be decoded
+var ErrMalformedRequest = errors.New("tcp: malformed request")
+
+//Server describes a server listening for tcp messages from tcp clients.
+//Every accepted connection is kept open and may carry any number of concurrent requests
 type Server struct {
 	ID          string
 	Address     string
 	GovecLogger *govec.GoLog
+
+	m        sync.Mutex
+	sessions map[*session]bool
 }
 
+//Connection a single request received by the server. The response to Recv must be sent on Send
 type Connection struct {
 	Recv chan *Msg
 	Send chan *Msg
+	//Closed is closed when the remote end disconnects before a response is sent
+	Closed chan struct{}
+}
+
+//session a connection accepted by the server
+type session struct {
+	conn net.Conn
+	//clientID the id of the client of the last request or Hello received on the session, guarded by the server mutex
+	clientID string
+	writeM   sync.Mutex
+	closed   chan struct{}
 }
 
 //Start Starts the server and listens for tcp messages
@@ -29,12 +53,15 @@ func (s *Server) Start(connectionsChannel chan *Connection) error {
 		goVecConfig.AppendLog = true
 		s.GovecLogger = govec.InitGoVector(s.ID, s.ID+"GoVector.log", goVecConfig)
 	}
+	s.m.Lock()
+	s.sessions = map[*session]bool{}
+	s.m.Unlock()
+	l, err := net.Listen("tcp4", s.Address)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
 	go func() {
-		l, err := net.Listen("tcp4", s.Address)
-		if err != nil {
-			log.Println(err)
-			panic(err)
-		}
 		defer l.Close()
 		for {
 			c, err := l.Accept()
@@ -42,27 +69,126 @@ func (s *Server) Start(connectionsChannel chan *Connection) error {
 				log.Println(err)
 				panic(err)
 			}
-			cChan := Connection{
-				Recv: make(chan *Msg),
-				Send: make(chan *Msg),
+			sess := &session{
+				conn:   c,
+				closed: make(chan struct{}),
 			}
-			go s.waitForResponse(c.(*net.TCPConn), &cChan)
-			connectionsChannel <- &cChan
+			s.m.Lock()
+			s.sessions[sess] = true
+			s.m.Unlock()
+			go s.serve(sess, connectionsChannel)
 		}
 	}()
 	return nil
 }
-func (s *Server) waitForResponse(c *net.TCPConn, conn *Connection) {
-	data := make([]byte, 2048)
-	n, err := c.Read(data)
+
+//Push sends a server initiated msg to every open connection of the client with the given id.
+//The msg is written without waiting for a response, so the client cannot fail it
+func (s *Server) Push(clientID string, msg *Msg) error {
+	push := *msg
+	push.ID = 0
+	if push.ClientID == "" {
+		push.ClientID = s.ID
+	}
+	s.m.Lock()
+	sessions := []*session{}
+	for sess := range s.sessions {
+		if sess.clientID == clientID {
+			sessions = append(sessions, sess)
+		}
+	}
+	s.m.Unlock()
+	if len(sessions) == 0 {
+		return ErrUnknownClient
+	}
+	var err error
+	for _, sess := range sessions {
+		e := sess.write(&push)
+		if e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+//serve reads the requests of a session until the remote end disconnects or sends a malformed request
+func (s *Server) serve(sess *session, connectionsChannel chan *Connection) {
+	defer func() {
+		s.m.Lock()
+		delete(s.sessions, sess)
+		s.m.Unlock()
+		close(sess.closed)
+		sess.conn.Close()
+	}()
+	for {
+		data, err := readFrame(sess.conn)
+		if err != nil {
+			log.Printf("TCP READ ERR: %s", err.Error())
+			return
+		}
+		msg := Msg{}
+		//UnpackReceive only logs decoding errors, a request that does not decode to a msg with an id is malformed.
+		//It cannot be answered on its own id, so the error is sent without one and the session is closed
+		s.GovecLogger.UnpackReceive("ReceivingMessage", data, &msg, govec.GetDefaultLogOptions())
+		if msg.ID == 0 {
+			log.Printf("TCP ERR: malformed request from %s", sess.conn.RemoteAddr())
+			err = sess.write(&Msg{
+				ClientID: s.ID,
+				MSGType:  Error,
+				Payload:  ErrMalformedRequest.Error(),
+			})
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
+		s.m.Lock()
+		sess.clientID = msg.ClientID
+		s.m.Unlock()
+		if msg.MSGType == Hello {
+			err = sess.write(&Msg{
+				ID:       msg.ID,
+				ClientID: s.ID,
+				MSGType:  Hello,
+			})
+			if err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+		conn := Connection{
+			Recv:   make(chan *Msg, 1),
+			Send:   make(chan *Msg, 1),
+			Closed: sess.closed,
+		}
+		conn.Recv <- &msg
+		go s.waitForResponse(sess, msg.ID, &conn)
+		connectionsChannel <- &conn
+	}
+}
+
+//waitForResponse writes the response of a single request back to its session
+func (s *Server) waitForResponse(sess *session, id uint64, conn *Connection) {
+	var response *Msg
+	select {
+	case response = <-conn.Send:
+	case <-sess.closed:
+		return
+	}
+	res := *response
+	res.ID = id
+	err := sess.write(&res)
 	if err != nil {
 		log.Println(err)
 	}
-	msg := Msg{}
-	s.GovecLogger.UnpackReceive("ReceivingMessage", data, &msg, govec.GetDefaultLogOptions())
-	err = json.Unmarshal(data[0:n], &msg)
-	conn.Recv <- &msg
-	response := <-conn.Send
-	resBytes, err := json.Marshal(response)
-	c.Write(resBytes)
+}
+
+func (sess *session) write(msg *Msg) error {
+	resBytes, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	sess.writeM.Lock()
+	defer sess.writeM.Unlock()
+	return writeFrame(sess.conn, resBytes)
 }
@@ -0,0 +1,235 @@
+package tcp_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DistributedClocks/GoVector/govec"
+	"github.com/KostasAronis/go-rfs/tcp"
+)
+
+func newLogger(t *testing.T, id string) *govec.GoLog {
+	config := govec.GetDefaultConfig()
+	config.LogToFile = false
+	return govec.InitGoVector(id, filepath.Join(t.TempDir(), id), config)
+}
+
+//startEchoServer starts a server that echoes every payload back to the client after delay
+func startEchoServer(t *testing.T, addr string, delay time.Duration) *tcp.Server {
+	server := tcp.Server{
+		ID:          "server",
+		Address:     addr,
+		GovecLogger: newLogger(t, "server"),
+	}
+	connections := make(chan *tcp.Connection)
+	err := server.Start(connections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for conn := range connections {
+			go func(conn *tcp.Connection) {
+				msg := <-conn.Recv
+				time.Sleep(delay)
+				conn.Send <- &tcp.Msg{
+					ClientID: "server",
+					MSGType:  msg.MSGType,
+					Payload:  msg.Payload,
+				}
+			}(conn)
+		}
+	}()
+	return &server
+}
+
+func TestLargeMessages(t *testing.T) {
+	server := startEchoServer(t, "127.0.0.1:17901", 0)
+	client := tcp.Client{
+		ID:          "client",
+		TargetAddr:  server.Address,
+		GovecLogger: newLogger(t, "client"),
+	}
+	defer client.Close()
+	for _, size := range []int{1, 2048, 4096, 64 * 1024} {
+		payload := strings.Repeat("a", size)
+		res, err := client.Send(&tcp.Msg{MSGType: tcp.ReadRec, Payload: payload}, "")
+		if err != nil {
+			t.Fatalf("Send of %d bytes failed: %s", size, err.Error())
+		}
+		if res.Payload != payload {
+			t.Errorf("Payload of %d bytes was not echoed back intact", size)
+		}
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	delay := 200 * time.Millisecond
+	server := startEchoServer(t, "127.0.0.1:17902", delay)
+	client := tcp.Client{
+		ID:          "client",
+		TargetAddr:  server.Address,
+		GovecLogger: newLogger(t, "client"),
+	}
+	defer client.Close()
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := fmt.Sprintf("msg%d", i)
+			res, err := client.Send(&tcp.Msg{MSGType: tcp.ReadRec, Payload: payload}, "")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if res.Payload != payload {
+				t.Errorf("Expected response %s got %v", payload, res.Payload)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if time.Since(start) > 5*delay {
+		t.Errorf("Requests were not served concurrently, took %s", time.Since(start))
+	}
+}
+
+func TestMalformedRequest(t *testing.T) {
+	server := startEchoServer(t, "127.0.0.1:17903", 0)
+	conn, err := net.Dial("tcp", server.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	payload := []byte("not a msg")
+	frame := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[4:], payload)
+	_, err = conn.Write(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	header := make([]byte, 4)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		t.Fatalf("Malformed request was not answered: %s", err.Error())
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header))
+	_, err = io.ReadFull(conn, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := tcp.Msg{}
+	err = json.Unmarshal(data, &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ID != 0 || res.MSGType != tcp.Error || res.Payload != tcp.ErrMalformedRequest.Error() {
+		t.Errorf("Expected an Error msg without id, got %+v", res)
+	}
+	_, err = conn.Read(header)
+	if err != io.EOF {
+		t.Errorf("Expected the session to be closed, got %v", err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := startEchoServer(t, "127.0.0.1:17904", 500*time.Millisecond)
+	client := tcp.Client{
+		ID:          "client",
+		TargetAddr:  server.Address,
+		GovecLogger: newLogger(t, "client"),
+		Timeout:     100 * time.Millisecond,
+	}
+	defer client.Close()
+	start := time.Now()
+	_, err := client.Send(&tcp.Msg{MSGType: tcp.Ping, Payload: "slow"}, "")
+	if err != tcp.ErrTimeout {
+		t.Errorf("Expected ErrTimeout, got %v", err)
+	}
+	if time.Since(start) > 400*time.Millisecond {
+		t.Errorf("Send should give up after its timeout, took %s", time.Since(start))
+	}
+	//the late response of the timed out request does not answer the next one
+	client.Timeout = 2 * time.Second
+	res, err := client.Send(&tcp.Msg{MSGType: tcp.Ping, Payload: "next"}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Payload != "next" {
+		t.Errorf("Expected the response of the second request, got %v", res.Payload)
+	}
+}
+
+func TestPush(t *testing.T) {
+	server := startEchoServer(t, "127.0.0.1:17905", 0)
+	client := tcp.Client{
+		ID:          "client",
+		TargetAddr:  server.Address,
+		GovecLogger: newLogger(t, "client"),
+		Pushes:      make(chan *tcp.Msg, 1),
+	}
+	defer client.Close()
+	err := server.Push("client", &tcp.Msg{MSGType: tcp.Block})
+	if err != tcp.ErrUnknownClient {
+		t.Errorf("Expected ErrUnknownClient before the client connected, got %v", err)
+	}
+	err = client.Listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the client registers without sending any request
+	deadline := time.Now().Add(2 * time.Second)
+	err = server.Push("client", &tcp.Msg{MSGType: tcp.Block, Payload: "pushed"})
+	for err == tcp.ErrUnknownClient && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		err = server.Push("client", &tcp.Msg{MSGType: tcp.Block, Payload: "pushed"})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case push := <-client.Pushes:
+		if push.MSGType != tcp.Block || push.Payload != "pushed" {
+			t.Errorf("Unexpected push %+v", push)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Push was not received")
+	}
+}
+
+func TestConcurrentDial(t *testing.T) {
+	//nothing listens on the target, so every dial attempt fails
+	client := tcp.Client{
+		ID:          "client",
+		TargetAddr:  "127.0.0.1:17906",
+		GovecLogger: newLogger(t, "client"),
+	}
+	defer client.Close()
+	start := time.Now()
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := client.Send(&tcp.Msg{MSGType: tcp.Ping}, "")
+			if err == nil {
+				t.Error("Send to an unreachable target should fail")
+			}
+		}()
+	}
+	wg.Wait()
+	//the Sends share a single dial with backoff instead of taking turns
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("Concurrent Sends should share the dial, took %s", time.Since(start))
+	}
+}